@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/osbtest"
+
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const clientUsage = `usage: worlds-simplest-service-broker client [flags] <command> [args]
+
+commands:
+  catalog
+  provision [instance-id]
+  get-instance <instance-id>
+  update <instance-id>
+  deprovision <instance-id>
+  bind <instance-id> [binding-id]
+  get-binding <instance-id> <binding-id>
+  unbind <instance-id> <binding-id>
+  poll <instance-id> [binding-id]
+  lifecycle
+
+flags:
+`
+
+// osbClient sends OSB API requests to a broker and pretty-prints the
+// responses, for trying the broker out by hand.
+type osbClient struct {
+	url        string
+	username   string
+	password   string
+	apiVersion string
+	identity   string
+
+	service   string
+	plan      string
+	params    string
+	operation string
+	async     bool
+	timeout   time.Duration
+
+	http *http.Client
+	out  io.Writer
+	log  io.Writer
+}
+
+type clientResponse struct {
+	path   string
+	status int
+	header http.Header
+	body   []byte
+}
+
+// runClient runs the client subcommand and returns its exit code.
+func runClient(args []string) int {
+	c := &osbClient{http: http.DefaultClient, out: os.Stdout, log: os.Stderr}
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.SetOutput(c.log)
+	flags.Usage = func() {
+		fmt.Fprint(c.log, clientUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&c.url, "url", getEnvWithDefault("BROKER_URL", "http://localhost:"+getEnvWithDefault("PORT", "3000")), "broker URL ($BROKER_URL)")
+	flags.StringVar(&c.username, "username", os.Getenv("AUTH_USER"), "basic auth username ($AUTH_USER)")
+	flags.StringVar(&c.password, "password", os.Getenv("AUTH_PASSWORD"), "basic auth password ($AUTH_PASSWORD)")
+	flags.StringVar(&c.apiVersion, "api-version", osbtest.DefaultAPIVersion, "X-Broker-API-Version")
+	flags.StringVar(&c.identity, "identity", "", `originating identity as platform:json, e.g. cloudfoundry:{"user_id":"683ea748"}`)
+	flags.StringVar(&c.service, "service", "", "service name or ID (default the first in the catalog)")
+	flags.StringVar(&c.plan, "plan", "", "plan name or ID (default the first of the service)")
+	flags.StringVar(&c.params, "params", "", "JSON parameters, or @file to read them from a file")
+	flags.StringVar(&c.operation, "operation", "", "operation to poll")
+	flags.BoolVar(&c.async, "async", false, "send accepts_incomplete=true and poll until the operation finishes")
+	flags.DurationVar(&c.timeout, "timeout", 5*time.Minute, "how long to poll an async operation")
+
+	positional, err := parseInterleaved(flags, args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := c.run(positional[0], positional[1:]); err != nil {
+		fmt.Fprintln(c.log, err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterleaved parses flags that come before, between or after the
+// positional arguments, and returns the positional arguments.
+func parseInterleaved(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func (c *osbClient) run(command string, args []string) error {
+	arg := func(i int, name string) (string, error) {
+		if i < len(args) {
+			return args[i], nil
+		}
+		return "", fmt.Errorf("%s needs a %s", command, name)
+	}
+	switch command {
+	case "catalog":
+		return c.show(c.do("GET", "/v2/catalog", nil, nil))
+	case "provision":
+		instanceID := uuid.New()
+		if len(args) > 0 {
+			instanceID = args[0]
+		}
+		return c.provision(instanceID)
+	case "get-instance":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		return c.show(c.do("GET", instancePath(instanceID), nil, nil))
+	case "update":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		return c.update(instanceID)
+	case "deprovision":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		return c.remove(instancePath(instanceID))
+	case "bind":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		bindingID := uuid.New()
+		if len(args) > 1 {
+			bindingID = args[1]
+		}
+		return c.bind(instanceID, bindingID)
+	case "get-binding", "unbind":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		bindingID, err := arg(1, "binding ID")
+		if err != nil {
+			return err
+		}
+		path := bindingPath(instanceID, bindingID)
+		if command == "unbind" {
+			return c.remove(path)
+		}
+		return c.show(c.do("GET", path, nil, nil))
+	case "poll":
+		instanceID, err := arg(0, "instance ID")
+		if err != nil {
+			return err
+		}
+		path := instancePath(instanceID)
+		if len(args) > 1 {
+			path = bindingPath(instanceID, args[1])
+		}
+		return c.poll(path, c.operation)
+	case "lifecycle":
+		return c.lifecycle()
+	default:
+		return fmt.Errorf("unknown client command %q", command)
+	}
+}
+
+func instancePath(instanceID string) string {
+	return "/v2/service_instances/" + url.PathEscape(instanceID)
+}
+
+func bindingPath(instanceID, bindingID string) string {
+	return instancePath(instanceID) + "/service_bindings/" + url.PathEscape(bindingID)
+}
+
+func (c *osbClient) provision(instanceID string) error {
+	service, plan, err := c.findPlan()
+	if err != nil {
+		return err
+	}
+	parameters, err := c.parameters()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.log, "instance %s\n", instanceID)
+	orgGUID, spaceGUID := uuid.New(), uuid.New()
+	return c.wait(c.do("PUT", instancePath(instanceID), c.asyncQuery(nil), map[string]interface{}{
+		"service_id":        service.ID,
+		"plan_id":           plan.ID,
+		"organization_guid": orgGUID,
+		"space_guid":        spaceGUID,
+		"parameters":        parameters,
+		"context": map[string]interface{}{
+			"platform":          "cloudfoundry",
+			"organization_guid": orgGUID,
+			"space_guid":        spaceGUID,
+		},
+	}))
+}
+
+func (c *osbClient) update(instanceID string) error {
+	service, plan, err := c.findPlan()
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{"service_id": service.ID}
+	if c.plan != "" {
+		body["plan_id"] = plan.ID
+	}
+	parameters, err := c.parameters()
+	if err != nil {
+		return err
+	}
+	if parameters != nil {
+		body["parameters"] = parameters
+	}
+	return c.wait(c.do("PATCH", instancePath(instanceID), c.asyncQuery(nil), body))
+}
+
+func (c *osbClient) bind(instanceID, bindingID string) error {
+	service, plan, err := c.findPlan()
+	if err != nil {
+		return err
+	}
+	parameters, err := c.parameters()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.log, "binding %s\n", bindingID)
+	appGUID := uuid.New()
+	path := bindingPath(instanceID, bindingID)
+	return c.wait(c.do("PUT", path, c.asyncQuery(nil), map[string]interface{}{
+		"service_id":    service.ID,
+		"plan_id":       plan.ID,
+		"app_guid":      appGUID,
+		"bind_resource": map[string]interface{}{"app_guid": appGUID},
+		"parameters":    parameters,
+	}))
+}
+
+// remove deprovisions an instance or deletes a binding. The spec requires
+// service_id and plan_id on both; they are looked up in the catalog unless
+// -service and -plan are given.
+func (c *osbClient) remove(path string) error {
+	service, plan, err := c.findPlan()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"service_id": {service.ID}, "plan_id": {plan.ID}}
+	return c.wait(c.do("DELETE", path, c.asyncQuery(query), nil))
+}
+
+// wait shows a response and, if it is 202 Accepted, polls last_operation
+// until the operation finishes.
+func (c *osbClient) wait(resp clientResponse, err error) error {
+	if err := c.show(resp, err); err != nil {
+		return err
+	}
+	if resp.status != http.StatusAccepted {
+		return nil
+	}
+	var accepted brokerapi.AsyncBindResponse
+	json.Unmarshal(resp.body, &accepted)
+	return c.poll(resp.path, accepted.OperationData)
+}
+
+func (c *osbClient) poll(path, operation string) error {
+	query := url.Values{}
+	if operation != "" {
+		query.Set("operation", operation)
+	}
+	deadline := time.Now().Add(c.timeout)
+	for {
+		resp, err := c.do("GET", path+"/last_operation", query, nil)
+		if err == nil && resp.status == http.StatusGone {
+			// A finished deprovision or unbind.
+			return nil
+		}
+		if err := c.show(resp, err); err != nil {
+			return err
+		}
+		var lastOperation brokerapi.LastOperationResponse
+		if err := json.Unmarshal(resp.body, &lastOperation); err != nil {
+			return fmt.Errorf("decoding last_operation: %s", err)
+		}
+		switch lastOperation.State {
+		case brokerapi.Succeeded:
+			return nil
+		case brokerapi.Failed:
+			return fmt.Errorf("operation failed: %s", lastOperation.Description)
+		}
+		wait := 2 * time.Second
+		if seconds, err := strconv.Atoi(resp.header.Get("Retry-After")); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("operation still in progress after %s", c.timeout)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// lifecycle runs the OSB conformance checks against the broker.
+func (c *osbClient) lifecycle() error {
+	suite := osbtest.Suite{
+		URL:        c.url,
+		Client:     c.http,
+		Username:   c.username,
+		Password:   c.password,
+		APIVersion: c.apiVersion,
+		Timeout:    c.timeout,
+	}
+	if c.service != "" || c.plan != "" {
+		service, plan, err := c.findPlan()
+		if err != nil {
+			return err
+		}
+		suite.ServiceID, suite.PlanID = service.ID, plan.ID
+	}
+	if !suite.Run(&clientReporter{out: c.out}) {
+		return errors.New("lifecycle failed")
+	}
+	fmt.Fprintln(c.out, "lifecycle passed")
+	return nil
+}
+
+// clientReporter prints the conformance checks as they run.
+type clientReporter struct {
+	out io.Writer
+}
+
+func (r *clientReporter) Helper() {}
+
+func (r *clientReporter) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, "FAIL "+format+"\n", args...)
+}
+
+func (r *clientReporter) Logf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, "ok   "+format+"\n", args...)
+}
+
+// findPlan looks up -service and -plan, by name or ID, in the broker's
+// catalog.
+func (c *osbClient) findPlan() (brokerapi.Service, brokerapi.ServicePlan, error) {
+	resp, err := c.do("GET", "/v2/catalog", nil, nil)
+	if err != nil {
+		return brokerapi.Service{}, brokerapi.ServicePlan{}, err
+	}
+	if resp.status != http.StatusOK {
+		return brokerapi.Service{}, brokerapi.ServicePlan{}, fmt.Errorf("fetching catalog: %d %s", resp.status, resp.body)
+	}
+	var catalog brokerapi.CatalogResponse
+	if err := json.Unmarshal(resp.body, &catalog); err != nil {
+		return brokerapi.Service{}, brokerapi.ServicePlan{}, fmt.Errorf("decoding catalog: %s", err)
+	}
+	for _, service := range catalog.Services {
+		if c.service != "" && c.service != service.Name && c.service != service.ID {
+			continue
+		}
+		for _, plan := range service.Plans {
+			if c.plan == "" || c.plan == plan.Name || c.plan == plan.ID {
+				return service, plan, nil
+			}
+		}
+	}
+	return brokerapi.Service{}, brokerapi.ServicePlan{}, fmt.Errorf("no plan %q of service %q in the catalog", c.plan, c.service)
+}
+
+func (c *osbClient) parameters() (interface{}, error) {
+	raw := c.params
+	if raw == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(raw, "@") {
+		data, err := ioutil.ReadFile(raw[1:])
+		if err != nil {
+			return nil, err
+		}
+		raw = string(data)
+	}
+	var parameters interface{}
+	if err := json.Unmarshal([]byte(raw), &parameters); err != nil {
+		return nil, fmt.Errorf("invalid -params: %s", err)
+	}
+	return parameters, nil
+}
+
+func (c *osbClient) asyncQuery(query url.Values) url.Values {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.async {
+		query.Set("accepts_incomplete", "true")
+	}
+	return query
+}
+
+// originatingIdentity encodes -identity as the spec's
+// X-Broker-API-Originating-Identity header: the platform, then the
+// base64-encoded JSON.
+func (c *osbClient) originatingIdentity() (string, error) {
+	parts := strings.SplitN(c.identity, ":", 2)
+	if len(parts) != 2 || !json.Valid([]byte(parts[1])) {
+		return "", fmt.Errorf("invalid -identity %q: expected platform:json", c.identity)
+	}
+	return parts[0] + " " + base64.StdEncoding.EncodeToString([]byte(parts[1])), nil
+}
+
+func (c *osbClient) do(method, path string, query url.Values, body interface{}) (clientResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return clientResponse{}, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := strings.TrimRight(c.url, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return clientResponse{}, err
+	}
+	req.Header.Set("X-Broker-API-Version", c.apiVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.identity != "" {
+		identity, err := c.originatingIdentity()
+		if err != nil {
+			return clientResponse{}, err
+		}
+		req.Header.Set("X-Broker-API-Originating-Identity", identity)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return clientResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return clientResponse{}, err
+	}
+	fmt.Fprintf(c.log, "%s %s: %s\n", method, path, resp.Status)
+	return clientResponse{path: path, status: resp.StatusCode, header: resp.Header, body: data}, nil
+}
+
+// show pretty-prints a response body and turns error statuses into errors.
+func (c *osbClient) show(resp clientResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, bytes.TrimSpace(resp.body), "", "  ") == nil {
+		pretty.WriteTo(c.out)
+		fmt.Fprintln(c.out)
+	} else if len(resp.body) > 0 {
+		c.out.Write(resp.body)
+	}
+	if resp.status >= 400 {
+		return fmt.Errorf("broker returned %d", resp.status)
+	}
+	return nil
+}
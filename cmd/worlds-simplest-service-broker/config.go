@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+// loadConfig builds the broker configuration from environment variables.
+func loadConfig() (broker.Config, error) {
+	var credentials interface{}
+	if err := json.Unmarshal([]byte(getEnvWithDefault("CREDENTIALS", "{\"port\": \"4000\"}")), &credentials); err != nil {
+		return broker.Config{}, fmt.Errorf("invalid CREDENTIALS: %s", err)
+	}
+
+	config := broker.Config{
+		BaseGUID:    getEnvWithDefault("BASE_GUID", "29140B3F-0E69-4C7E-8A35"),
+		IDMode:      getEnvWithDefault("ID_MODE", broker.LegacyIDs),
+		ServiceName: getEnvWithDefault("SERVICE_NAME", "some-service-name"),
+		ServicePlan: getEnvWithDefault("SERVICE_PLAN_NAME", "shared"),
+		Credentials: credentials,
+		Tags:        getEnvWithDefault("TAGS", "shared,worlds-simplest-service-broker"),
+		ImageURL:    os.Getenv("IMAGE_URL"),
+
+		FakeAsync:    os.Getenv("FAKE_ASYNC") == "true",
+		FakeStateful: os.Getenv("FAKE_STATEFUL") == "true",
+
+		BindingCredentials:   getEnvWithDefault("BINDING_CREDENTIALS", broker.SnapshotCredentials),
+		RouteServiceProxyURL: os.Getenv("ROUTE_SERVICE_PROXY_URL"),
+
+		ServiceDescription:  os.Getenv("SERVICE_DESCRIPTION"),
+		LongDescription:     os.Getenv("SERVICE_LONG_DESCRIPTION"),
+		ProviderDisplayName: os.Getenv("PROVIDER_DISPLAY_NAME"),
+		DocumentationURL:    os.Getenv("DOCUMENTATION_URL"),
+		SupportURL:          os.Getenv("SUPPORT_URL"),
+	}
+
+	if config.Dashboard.URL = os.Getenv("DASHBOARD_URL"); config.Dashboard.URL != "" {
+		config.Dashboard.Secret = []byte(os.Getenv("DASHBOARD_SECRET"))
+		ttl, err := time.ParseDuration(getEnvWithDefault("DASHBOARD_TOKEN_TTL", "24h"))
+		if err != nil {
+			return config, fmt.Errorf("invalid DASHBOARD_TOKEN_TTL: %s", err)
+		}
+		config.Dashboard.TokenTTL = ttl
+	}
+
+	var err error
+	if retryAfter := os.Getenv("RETRY_AFTER"); retryAfter != "" {
+		if config.RetryAfter, err = time.ParseDuration(retryAfter); err != nil {
+			return config, fmt.Errorf("invalid RETRY_AFTER: %s", err)
+		}
+	}
+	if duration := os.Getenv("OPERATION_DURATION"); duration != "" {
+		if config.OperationDuration, err = time.ParseDuration(duration); err != nil {
+			return config, fmt.Errorf("invalid OPERATION_DURATION: %s", err)
+		}
+	}
+
+	if config.CatalogFile = os.Getenv("CATALOG_FILE"); config.CatalogFile != "" {
+		if config.Catalog, err = broker.LoadCatalogFile(config.CatalogFile); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// vcapService is an entry of VCAP_SERVICES, with the fields in the order
+// Cloud Foundry writes them.
+type vcapService struct {
+	Label          string                  `json:"label"`
+	Provider       *string                 `json:"provider"`
+	Plan           string                  `json:"plan"`
+	Name           string                  `json:"name"`
+	Tags           []string                `json:"tags"`
+	InstanceGUID   string                  `json:"instance_guid"`
+	InstanceName   string                  `json:"instance_name"`
+	BindingGUID    string                  `json:"binding_guid"`
+	BindingName    *string                 `json:"binding_name"`
+	Credentials    interface{}             `json:"credentials"`
+	SyslogDrainURL *string                 `json:"syslog_drain_url"`
+	VolumeMounts   []brokerapi.VolumeMount `json:"volume_mounts"`
+}
+
+// The name is a directory under bindings/ and the Secret's metadata.name, so
+// it must be a DNS-1123 label.
+var localBindingName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// localBinding renders a binding of a plan as VCAP_SERVICES or as a
+// Kubernetes Secret on stdout, or writes VCAP_SERVICES.json, secret.yaml
+// and a servicebinding.io directory to -out.
+func localBinding(bkr *broker.BrokerImpl, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("local-binding", flag.ExitOnError)
+	service := flags.String("service", "", "service name or ID (default the first in the catalog)")
+	plan := flags.String("plan", "", "plan name or ID (default the first of the service)")
+	name := flags.String("name", "", "instance name (default the service name)")
+	format := flags.String("format", "vcap", "what to print: vcap or secret")
+	outDir := flags.String("out", "", "write VCAP_SERVICES.json, secret.yaml and bindings/<name> to this directory instead")
+	flags.Parse(args)
+
+	binding, err := bkr.LocalBinding(*service, *plan, *name)
+	if err != nil {
+		return err
+	}
+	if len(binding.Name) > 63 || !localBindingName.MatchString(binding.Name) {
+		return fmt.Errorf("name %q must be a DNS-1123 label: at most 63 lowercase letters, digits and '-', starting and ending with a letter or digit (set -name)", binding.Name)
+	}
+	vcap, err := vcapServices(binding)
+	if err != nil {
+		return err
+	}
+	entries, err := broker.ServiceBindingEntries(binding.Credentials, binding.Type, binding.Provider)
+	if err != nil {
+		return err
+	}
+	secret := kubernetesSecret(binding, entries)
+
+	if *outDir == "" {
+		switch *format {
+		case "vcap":
+			_, err = out.Write(vcap)
+		case "secret":
+			_, err = out.Write(secret)
+		default:
+			err = fmt.Errorf("unknown -format %q (expected vcap or secret)", *format)
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(*outDir, "VCAP_SERVICES.json"), vcap, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(*outDir, "secret.yaml"), secret, 0644); err != nil {
+		return err
+	}
+	bindingDir := filepath.Join(*outDir, "bindings", binding.Name)
+	if err := os.RemoveAll(bindingDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(bindingDir, 0755); err != nil {
+		return err
+	}
+	for key, value := range entries {
+		if err := ioutil.WriteFile(filepath.Join(bindingDir, key), []byte(value), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func vcapServices(binding broker.LocalBinding) ([]byte, error) {
+	service := vcapService{
+		Label:        binding.Label,
+		Plan:         binding.Plan,
+		Name:         binding.Name,
+		Tags:         binding.Tags,
+		InstanceGUID: binding.InstanceID,
+		InstanceName: binding.Name,
+		BindingGUID:  binding.BindingID,
+		Credentials:  binding.Credentials,
+		VolumeMounts: binding.VolumeMounts,
+	}
+	if service.Tags == nil {
+		service.Tags = []string{}
+	}
+	if service.VolumeMounts == nil {
+		service.VolumeMounts = []brokerapi.VolumeMount{}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(map[string][]vcapService{binding.Label: {service}})
+	return buf.Bytes(), err
+}
+
+// kubernetesSecret renders the servicebinding.io entries as a Secret
+// manifest. Values are written as JSON strings, which are valid YAML.
+func kubernetesSecret(binding broker.LocalBinding, entries map[string]string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\ntype: %s\nstringData:\n", quoteYAML(binding.Name), quoteYAML("servicebinding.io/"+entries["type"]))
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "  %s: %s\n", quoteYAML(key), quoteYAML(entries[key]))
+	}
+	return buf.Bytes()
+}
+
+func quoteYAML(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
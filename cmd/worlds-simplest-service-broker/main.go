@@ -1,34 +1,207 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/admin"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/api"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/auth"
 	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/dashboard"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/routeservice"
 
 	"code.cloudfoundry.org/lager"
-	"github.com/pivotal-cf/brokerapi"
+	"github.com/gorilla/mux"
 )
 
 func statusAPI(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "OK")
 }
 
+func readyAPI(authMode string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":    "ok",
+			"auth_mode": authMode,
+		})
+	}
+}
+
+func unauthenticated(handler http.Handler) http.Handler {
+	return handler
+}
+
+func newBasicAuth(logger lager.Logger) *auth.BasicAuth {
+	if (os.Getenv("AUTH_USER") == "") != (os.Getenv("AUTH_PASSWORD") == "") {
+		logger.Fatal("auth", errors.New("AUTH_USER and AUTH_PASSWORD must be set together"))
+	}
+	credentials := []auth.Credential{{
+		Username: os.Getenv("AUTH_USER"),
+		Password: os.Getenv("AUTH_PASSWORD"),
+	}}
+	if raw := os.Getenv("AUTH_CREDENTIALS"); raw != "" {
+		extra, err := auth.ParseCredentials([]byte(raw))
+		if err != nil {
+			logger.Fatal("auth-credentials", err)
+		}
+		credentials = append(credentials, extra...)
+	}
+	basicAuth := auth.NewBasicAuth(credentials...)
+	if path := os.Getenv("AUTH_CREDENTIALS_FILE"); path != "" {
+		if err := basicAuth.LoadFile(path); err != nil {
+			logger.Fatal("auth-credentials-file", err)
+		}
+		go basicAuth.WatchFile(path, 10*time.Second, logger)
+	}
+	return basicAuth
+}
+
+func newJWTAuth(logger lager.Logger) *auth.JWTAuth {
+	jwtAuth, err := auth.NewJWTAuth(auth.JWTConfig{
+		JWKSFile:      os.Getenv("JWT_JWKS_FILE"),
+		JWKSURL:       os.Getenv("JWT_JWKS_URL"),
+		Issuer:        os.Getenv("JWT_ISSUER"),
+		Audience:      os.Getenv("JWT_AUDIENCE"),
+		RequiredScope: os.Getenv("JWT_REQUIRED_SCOPE"),
+	}, logger)
+	if err != nil {
+		logger.Fatal("jwt-auth", err)
+	}
+	return jwtAuth
+}
+
+// newAuthorizer builds the broker API authentication from AUTH_MODE, a
+// comma separated list of "basic" and "jwt" (default "basic"). A request is
+// accepted if any of the listed modes accepts it. The authorizer is nil when
+// authentication is disabled.
+func newAuthorizer(logger lager.Logger) (string, auth.Authorizer) {
+	modes := []string{}
+	authorizers := []auth.Authorizer{}
+	for _, mode := range strings.Split(getEnvWithDefault("AUTH_MODE", "basic"), ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case "basic":
+			basicAuth := newBasicAuth(logger)
+			for _, username := range basicAuth.WeakUsernames() {
+				logger.Error("auth-weak-password", errors.New("weak password for broker user"), lager.Data{"username": username})
+			}
+			if len(basicAuth.Credentials()) > 0 {
+				modes = append(modes, mode)
+				authorizers = append(authorizers, basicAuth)
+			}
+		case "jwt":
+			modes = append(modes, mode)
+			authorizers = append(authorizers, newJWTAuth(logger))
+		default:
+			logger.Fatal("auth", fmt.Errorf("unknown AUTH_MODE %q", mode))
+		}
+	}
+
+	if len(authorizers) == 0 {
+		if os.Getenv("ALLOW_UNAUTHENTICATED") != "true" {
+			logger.Fatal("auth", errors.New("no broker credentials configured: set AUTH_USER and AUTH_PASSWORD, or ALLOW_UNAUTHENTICATED=true"))
+		}
+		logger.Error("auth-disabled", errors.New("ALLOW_UNAUTHENTICATED=true: anyone who can reach the broker can fetch binding credentials"))
+		return "none", nil
+	}
+	return strings.Join(modes, ","), auth.AnyOf(authorizers)
+}
+
+// printIDs prints the ID of each service and plan in the catalog, and what
+// it would be with ID_MODE=legacy and ID_MODE=uuid.
+func printIDs(bkr *broker.BrokerImpl, out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tPLAN\tID\tLEGACY\tUUID")
+	for _, mapping := range bkr.IDMappings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", mapping.Service, mapping.Plan, mapping.ID, mapping.Legacy, mapping.UUID)
+	}
+	w.Flush()
+}
+
+func reloadOnSIGHUP(bkr *broker.BrokerImpl, logger lager.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		bkr.LogReloadCatalog(logger.Session("sighup"))
+	}
+}
+
+func getEnvWithDefault(key, defaultValue string) string {
+	if os.Getenv(key) == "" {
+		return defaultValue
+	}
+	return os.Getenv(key)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(runClient(os.Args[2:]))
+	}
+
 	logger := lager.NewLogger("worlds-simplest-service-broker")
 	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
 	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
 
-	servicebroker := broker.NewBrokerImpl(logger)
+	config, err := loadConfig()
+	if err != nil {
+		logger.Fatal("config", err)
+	}
+	servicebroker, err := broker.New(config, broker.WithLogger(logger))
+	if err != nil {
+		logger.Fatal("broker", err)
+	}
 
-	brokerCredentials := brokerapi.BrokerCredentials{
-		Username: os.Getenv("AUTH_USER"),
-		Password: os.Getenv("AUTH_PASSWORD"),
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ids":
+			printIDs(servicebroker, os.Stdout)
+		case "local-binding":
+			if err := localBinding(servicebroker, os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
+		return
+	}
+
+	fmt.Printf("Credentials: %v\n", config.Credentials)
+	if servicebroker.Config.CatalogFile != "" {
+		go servicebroker.WatchCatalogFile(10 * time.Second)
 	}
-	brokerAPI := brokerapi.New(servicebroker, logger, brokerCredentials)
+	go reloadOnSIGHUP(servicebroker, logger)
+
+	authMode, authorizer := newAuthorizer(logger)
+	logger.Info("auth", lager.Data{"mode": authMode})
+	authMiddleware := mux.MiddlewareFunc(unauthenticated)
+	if authorizer != nil {
+		authMiddleware = auth.Middleware(authorizer)
+	}
+
+	brokerAPI := api.New(servicebroker, logger, authMiddleware)
+	adminAPI := authMiddleware(admin.NewHandler(servicebroker, logger))
 
 	http.HandleFunc("/health", statusAPI)
+	http.HandleFunc("/readyz", readyAPI(authMode))
+	http.Handle("/admin/", adminAPI)
+	if servicebroker.Config.Dashboard.URL != "" {
+		http.Handle("/dashboard/", dashboard.NewHandler(servicebroker, authorizer, logger))
+	}
+	if servicebroker.Config.RouteServiceProxyURL != "" {
+		http.Handle("/route/", routeservice.NewHandler(servicebroker, logger))
+	}
 	http.Handle("/", brokerAPI)
 
 	port := os.Getenv("PORT")
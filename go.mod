@@ -6,7 +6,8 @@ require (
 	code.cloudfoundry.org/lager v2.0.0+incompatible
 	github.com/gorilla/context v1.1.1
 	github.com/gorilla/mux v1.6.2
-	github.com/pborman/uuid v1.2.0 // indirect
+	github.com/pborman/uuid v1.2.0
 	github.com/pivotal-cf/brokerapi v6.4.1+incompatible
 	github.com/pkg/errors v0.8.0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 )
@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+)
+
+// API serves /admin/v1, a JSON view of the instances and bindings the broker
+// knows about, for reconciling it with the platform.
+type API struct {
+	Broker *broker.BrokerImpl
+	Logger lager.Logger
+}
+
+type errorResponse struct {
+	Description string `json:"description"`
+}
+
+func NewHandler(bkr *broker.BrokerImpl, logger lager.Logger) http.Handler {
+	api := &API{Broker: bkr, Logger: logger.Session("admin")}
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/v1/instances", api.listInstances).Methods("GET")
+	router.HandleFunc("/admin/v1/instances/{instance_id}", api.getInstance).Methods("GET")
+	router.HandleFunc("/admin/v1/instances/{instance_id}", api.deleteInstance).Methods("DELETE")
+	router.HandleFunc("/admin/v1/bindings", api.listBindings).Methods("GET")
+	router.HandleFunc("/admin/v1/bindings/stale", api.listStaleBindings).Methods("GET")
+	router.HandleFunc("/admin/v1/bindings/{binding_id}", api.getBinding).Methods("GET")
+	router.HandleFunc("/admin/v1/bindings/{binding_id}", api.deleteBinding).Methods("DELETE")
+	router.HandleFunc("/admin/v1/state", api.exportState).Methods("GET")
+	router.HandleFunc("/admin/v1/state", api.importState).Methods("PUT")
+	return router
+}
+
+func (api *API) respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(response); err != nil {
+		api.Logger.Error("encoding-response", err, lager.Data{"status": status})
+	}
+}
+
+func (api *API) respondError(w http.ResponseWriter, status int, err error) {
+	api.respond(w, status, errorResponse{Description: err.Error()})
+}
+
+// filter holds the query parameters shared by the list endpoints: plan (ID
+// or name), org, space, namespace, instance_id, and older_than/newer_than
+// durations such as "72h". shared=true or shared=false only applies to
+// bindings.
+type filter struct {
+	plan       string
+	org        string
+	space      string
+	namespace  string
+	instanceID string
+	shared     string
+	olderThan  time.Duration
+	newerThan  time.Duration
+}
+
+func parseFilter(r *http.Request) (filter, error) {
+	query := r.URL.Query()
+	f := filter{
+		plan:       query.Get("plan"),
+		org:        query.Get("org"),
+		space:      query.Get("space"),
+		namespace:  query.Get("namespace"),
+		instanceID: query.Get("instance_id"),
+		shared:     query.Get("shared"),
+	}
+	if f.shared != "" && f.shared != "true" && f.shared != "false" {
+		return f, fmt.Errorf("invalid shared: %q", f.shared)
+	}
+	var err error
+	if v := query.Get("older_than"); v != "" {
+		if f.olderThan, err = time.ParseDuration(v); err != nil {
+			return f, fmt.Errorf("invalid older_than: %s", err)
+		}
+	}
+	if v := query.Get("newer_than"); v != "" {
+		if f.newerThan, err = time.ParseDuration(v); err != nil {
+			return f, fmt.Errorf("invalid newer_than: %s", err)
+		}
+	}
+	return f, nil
+}
+
+func (f filter) matches(bkr *broker.BrokerImpl, planID string, platformContext broker.PlatformContext, createdAt time.Time) bool {
+	if f.plan != "" && f.plan != planID {
+		_, plan, ok := bkr.Catalog().FindPlan(planID)
+		if !ok || plan.Name != f.plan {
+			return false
+		}
+	}
+	if f.org != "" && f.org != platformContext.OrganizationGUID {
+		return false
+	}
+	if f.space != "" && f.space != platformContext.SpaceGUID {
+		return false
+	}
+	if f.namespace != "" && f.namespace != platformContext.Namespace {
+		return false
+	}
+	age := bkr.Now().Sub(createdAt)
+	if f.olderThan != 0 && age < f.olderThan {
+		return false
+	}
+	if f.newerThan != 0 && age > f.newerThan {
+		return false
+	}
+	return true
+}
+
+func (api *API) listInstances(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r)
+	if err != nil {
+		api.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	instances := []broker.Instance{}
+	for _, instance := range api.Broker.ListInstances() {
+		if f.instanceID != "" && f.instanceID != instance.ID {
+			continue
+		}
+		if f.matches(api.Broker, instance.PlanID, instance.Context, instance.CreatedAt) {
+			instances = append(instances, instance)
+		}
+	}
+	api.respond(w, http.StatusOK, instances)
+}
+
+func (api *API) getInstance(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instance_id"]
+	if instance, ok := api.Broker.FindInstance(instanceID); ok {
+		api.respond(w, http.StatusOK, instance)
+		return
+	}
+	api.respondError(w, http.StatusNotFound, fmt.Errorf("Unknown instance ID %s", instanceID))
+}
+
+func (api *API) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instance_id"]
+	if !api.Broker.DeleteInstance(instanceID) {
+		api.respondError(w, http.StatusNotFound, fmt.Errorf("Unknown instance ID %s", instanceID))
+		return
+	}
+	api.Logger.Info("force-deleted-instance", lager.Data{"instance-id": instanceID})
+	api.respond(w, http.StatusOK, struct{}{})
+}
+
+func (api *API) listBindings(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r)
+	if err != nil {
+		api.respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	instances := map[string]broker.Instance{}
+	for _, instance := range api.Broker.ListInstances() {
+		instances[instance.ID] = instance
+	}
+	bindings := []broker.Binding{}
+	for _, binding := range api.Broker.ListBindings() {
+		if f.instanceID != "" && f.instanceID != binding.InstanceID {
+			continue
+		}
+		if f.shared != "" && f.shared != strconv.FormatBool(binding.Shared) {
+			continue
+		}
+		// org, space and namespace filters apply to the bound instance
+		platformContext := instances[binding.InstanceID].Context
+		if f.matches(api.Broker, binding.PlanID, platformContext, binding.CreatedAt) {
+			bindings = append(bindings, binding)
+		}
+	}
+	api.respond(w, http.StatusOK, bindings)
+}
+
+func (api *API) listStaleBindings(w http.ResponseWriter, r *http.Request) {
+	api.respond(w, http.StatusOK, api.Broker.StaleBindings())
+}
+
+func (api *API) getBinding(w http.ResponseWriter, r *http.Request) {
+	bindingID := mux.Vars(r)["binding_id"]
+	if binding, ok := api.Broker.FindBinding(bindingID); ok {
+		api.respond(w, http.StatusOK, binding)
+		return
+	}
+	api.respondError(w, http.StatusNotFound, fmt.Errorf("Unknown binding ID %s", bindingID))
+}
+
+func (api *API) deleteBinding(w http.ResponseWriter, r *http.Request) {
+	bindingID := mux.Vars(r)["binding_id"]
+	if !api.Broker.DeleteBinding(bindingID) {
+		api.respondError(w, http.StatusNotFound, fmt.Errorf("Unknown binding ID %s", bindingID))
+		return
+	}
+	api.Logger.Info("force-deleted-binding", lager.Data{"binding-id": bindingID})
+	api.respond(w, http.StatusOK, struct{}{})
+}
+
+func (api *API) exportState(w http.ResponseWriter, r *http.Request) {
+	api.respond(w, http.StatusOK, api.Broker.ExportState())
+}
+
+func (api *API) importState(w http.ResponseWriter, r *http.Request) {
+	var state broker.State
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		api.respondError(w, http.StatusBadRequest, fmt.Errorf("invalid state: %s", err))
+		return
+	}
+	api.Broker.ImportState(state)
+	api.Logger.Info("imported-state", lager.Data{"instances": len(state.Instances), "bindings": len(state.Bindings)})
+	api.respond(w, http.StatusOK, struct{}{})
+}
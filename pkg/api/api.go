@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/middlewares"
+)
+
+// New returns the OSB API handler for bkr. It is brokerapi.New with
+// pluggable authentication, catalog, instance and binding responses that
+// include fields newer than brokerapi knows about, and Retry-After headers on
+// async responses and last_operation polls.
+func New(bkr *broker.BrokerImpl, logger lager.Logger, authMiddleware mux.MiddlewareFunc) http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/catalog", catalog(bkr, logger)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}", getInstance(bkr, logger)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", getBinding(bkr, logger)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/last_operation", lastOperation(bkr, logger)).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation", lastOperation(bkr, logger)).Methods("GET")
+	brokerapi.AttachRoutes(router, bkr, logger)
+
+	apiVersionMiddleware := middlewares.APIVersionMiddleware{LoggerFactory: logger}
+
+	router.Use(middlewares.AddCorrelationIDToContext)
+	router.Use(authMiddleware)
+	router.Use(middlewares.AddOriginatingIdentityToContext)
+	router.Use(apiVersionMiddleware.ValidateAPIVersionHdr)
+	router.Use(addAPIVersionToContext)
+	router.Use(middlewares.AddInfoLocationToContext)
+	router.Use(retryAfter(bkr))
+
+	return router
+}
+
+func addAPIVersionToContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		version, err := broker.ParseAPIVersion(req.Header.Get("X-Broker-API-Version"))
+		if err == nil {
+			req = req.WithContext(broker.WithAPIVersion(req.Context(), version))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func respond(w http.ResponseWriter, status int, response interface{}, logger lager.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		logger.Error("encoding-response", err, lager.Data{"status": status})
+	}
+}
+
+func respondError(w http.ResponseWriter, err error, logger lager.Logger) {
+	if failure, ok := err.(*brokerapi.FailureResponse); ok {
+		logger.Error(failure.LoggerAction(), failure)
+		respond(w, failure.ValidatedStatusCode(logger), failure.ErrorResponse(), logger)
+		return
+	}
+	logger.Error("unknown-error", err)
+	respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{Description: err.Error()}, logger)
+}
+
+func catalog(bkr *broker.BrokerImpl, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		response, err := bkr.CatalogResponse(req.Context())
+		if err != nil {
+			respondError(w, err, logger)
+			return
+		}
+		respond(w, http.StatusOK, response, logger)
+	}
+}
+
+type getInstanceResponse struct {
+	ServiceID       string                     `json:"service_id"`
+	PlanID          string                     `json:"plan_id"`
+	DashboardURL    string                     `json:"dashboard_url,omitempty"`
+	Parameters      interface{}                `json:"parameters,omitempty"`
+	MaintenanceInfo *brokerapi.MaintenanceInfo `json:"maintenance_info,omitempty"`
+}
+
+// getInstance is brokerapi's GET /v2/service_instances/:id, plus the
+// instance's maintenance_info for OSB 2.15 and newer.
+func getInstance(bkr *broker.BrokerImpl, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		instanceID := mux.Vars(req)["instance_id"]
+		logger := logger.Session("get-instance", lager.Data{"instance-id": instanceID})
+
+		version := broker.APIVersionFromContext(req.Context())
+		if !version.AtLeast(2, 14) {
+			respond(w, http.StatusPreconditionFailed, brokerapi.ErrorResponse{
+				Description: "get instance endpoint only supported starting with OSB version 2.14",
+			}, logger)
+			return
+		}
+
+		spec, err := bkr.GetInstance(req.Context(), instanceID)
+		if err != nil {
+			respondError(w, err, logger)
+			return
+		}
+		response := getInstanceResponse{
+			ServiceID:    spec.ServiceID,
+			PlanID:       spec.PlanID,
+			DashboardURL: spec.DashboardURL,
+			Parameters:   spec.Parameters,
+		}
+		if version.AtLeast(2, 15) {
+			response.MaintenanceInfo = bkr.InstanceMaintenanceInfo(instanceID)
+		}
+		respond(w, http.StatusOK, response, logger)
+	}
+}
+
+type getBindingResponse struct {
+	brokerapi.GetBindingResponse
+	Shared bool `json:"shared,omitempty"`
+}
+
+// getBinding is brokerapi's GET /v2/service_instances/:id/service_bindings/:id,
+// plus "shared": true for bindings from a space the instance is shared with.
+func getBinding(bkr *broker.BrokerImpl, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		instanceID, bindingID := vars["instance_id"], vars["binding_id"]
+		logger := logger.Session("get-binding", lager.Data{"instance-id": instanceID, "binding-id": bindingID})
+
+		if !broker.APIVersionFromContext(req.Context()).AtLeast(2, 14) {
+			respond(w, http.StatusPreconditionFailed, brokerapi.ErrorResponse{
+				Description: "get binding endpoint only supported starting with OSB version 2.14",
+			}, logger)
+			return
+		}
+
+		spec, err := bkr.GetBinding(req.Context(), instanceID, bindingID)
+		if err != nil {
+			respondError(w, err, logger)
+			return
+		}
+		response := getBindingResponse{
+			GetBindingResponse: brokerapi.GetBindingResponse{
+				BindingResponse: brokerapi.BindingResponse{
+					Credentials:     spec.Credentials,
+					SyslogDrainURL:  spec.SyslogDrainURL,
+					RouteServiceURL: spec.RouteServiceURL,
+					VolumeMounts:    spec.VolumeMounts,
+				},
+				Parameters: spec.Parameters,
+			},
+		}
+		if binding, ok := bkr.FindBinding(bindingID); ok {
+			response.Shared = binding.Shared
+		}
+		respond(w, http.StatusOK, response, logger)
+	}
+}
+
+// lastOperation is brokerapi's last_operation endpoints for instances and
+// bindings, plus a Retry-After header while the operation is in progress.
+func lastOperation(bkr *broker.BrokerImpl, logger lager.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		instanceID, bindingID := vars["instance_id"], vars["binding_id"]
+		logger := logger.Session("last-operation", lager.Data{"instance-id": instanceID, "binding-id": bindingID})
+
+		if bindingID != "" && !broker.APIVersionFromContext(req.Context()).AtLeast(2, 14) {
+			respond(w, http.StatusPreconditionFailed, brokerapi.ErrorResponse{
+				Description: "get binding endpoint only supported starting with OSB version 2.14",
+			}, logger)
+			return
+		}
+
+		operation, retryAfter, err := bkr.PollOperation(instanceID, bindingID, req.FormValue("operation"))
+		if err != nil {
+			respondError(w, err, logger)
+			return
+		}
+		if seconds := int(retryAfter.Seconds()); seconds > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		respond(w, http.StatusOK, brokerapi.LastOperationResponse{
+			State:       operation.State,
+			Description: operation.Description,
+		}, logger)
+	}
+}
+
+// retryAfterWriter adds a Retry-After header to 202 Accepted responses,
+// using the polling interval of the operation in the response body. The
+// status is held back until the body is written.
+type retryAfterWriter struct {
+	http.ResponseWriter
+	bkr      *broker.BrokerImpl
+	accepted bool
+}
+
+func (w *retryAfterWriter) WriteHeader(status int) {
+	if status == http.StatusAccepted {
+		w.accepted = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *retryAfterWriter) Write(body []byte) (int, error) {
+	if w.accepted {
+		w.accepted = false
+		var response struct {
+			Operation string `json:"operation"`
+		}
+		json.Unmarshal(body, &response)
+		if seconds := int(w.bkr.OperationRetryAfter(response.Operation).Seconds()); seconds > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		w.ResponseWriter.WriteHeader(http.StatusAccepted)
+	}
+	return w.ResponseWriter.Write(body)
+}
+
+func retryAfter(bkr *broker.BrokerImpl) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(&retryAfterWriter{ResponseWriter: w, bkr: bkr}, req)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func noAuth(handler http.Handler) http.Handler {
+	return handler
+}
+
+// TestCatalog compares GET /v2/catalog with testdata/<name>.golden.json. Run
+// go test ./pkg/api -update to rewrite the golden files after a deliberate
+// change.
+func TestCatalog(t *testing.T) {
+	catalog, err := broker.LoadCatalogFile(filepath.Join("testdata", "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFile := broker.Config{
+		BaseGUID:             "29140B3F-0E69-4C7E-8A35",
+		Tags:                 "shared,default-tag",
+		RouteServiceProxyURL: "https://proxy.example.com",
+		Catalog:              catalog,
+	}
+
+	tests := []struct {
+		name       string
+		config     broker.Config
+		apiVersion string
+	}{
+		{"file", fromFile, "2.16"},
+		// Platforms before OSB 2.15 get no plan_updateable or
+		// maximum_polling_duration on plans.
+		{"file-2.14", fromFile, "2.14"},
+		{"env", broker.Config{
+			BaseGUID:            "29140B3F-0E69-4C7E-8A35",
+			ServiceName:         "some-service",
+			ServicePlan:         "shared",
+			ServiceDescription:  "A shared service",
+			LongDescription:     "A longer description of the shared service.",
+			ProviderDisplayName: "Example Corp",
+			DocumentationURL:    "https://docs.example.com",
+			SupportURL:          "https://support.example.com",
+			ImageURL:            "https://example.com/image.png",
+			Tags:                "shared, mysql",
+		}, "2.16"},
+		{"env-paid", broker.Config{
+			BaseGUID:    "29140B3F-0E69-4C7E-8A35",
+			ServiceName: "some-service",
+			ServicePlan: "shared",
+			Paid:        true,
+		}, "2.16"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bkr, err := broker.New(test.config, broker.WithLogger(lager.NewLogger("test")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/v2/catalog", nil)
+			req.Header.Set("X-Broker-API-Version", test.apiVersion)
+			rec := httptest.NewRecorder()
+			New(bkr, lager.NewLogger("test"), noAuth).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET /v2/catalog: %d %s", rec.Code, rec.Body)
+			}
+
+			var got bytes.Buffer
+			if err := json.Indent(&got, rec.Body.Bytes(), "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", test.name+".golden.json")
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("GET /v2/catalog differs from %s; got:\n%s", golden, got.String())
+			}
+		})
+	}
+}
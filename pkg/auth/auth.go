@@ -0,0 +1,33 @@
+package auth
+
+import "net/http"
+
+// Authorizer decides whether a request to the broker API is authenticated.
+type Authorizer interface {
+	Authorized(r *http.Request) bool
+}
+
+// AnyOf accepts a request when at least one of its authorizers accepts it.
+type AnyOf []Authorizer
+
+func (authorizers AnyOf) Authorized(r *http.Request) bool {
+	for _, a := range authorizers {
+		if a.Authorized(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests the authorizer does not accept with a 401.
+func Middleware(authorizer Authorizer) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorizer.Authorized(r) {
+				http.Error(w, notAuthorized, http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
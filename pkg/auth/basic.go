@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const notAuthorized = "Not Authorized"
+
+// Credential is a single accepted username/password pair. The password may
+// be plain text or a bcrypt hash ($2a$, $2b$ or $2y$ prefix).
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c Credential) hashed() bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(c.Password, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Credential) matches(username, password string) bool {
+	if c.Username == "" || c.Password == "" || password == "" {
+		return false
+	}
+	u := sha256.Sum256([]byte(username))
+	expectedU := sha256.Sum256([]byte(c.Username))
+	if subtle.ConstantTimeCompare(u[:], expectedU[:]) != 1 {
+		return false
+	}
+	if c.hashed() {
+		return bcrypt.CompareHashAndPassword([]byte(c.Password), []byte(password)) == nil
+	}
+	p := sha256.Sum256([]byte(password))
+	expectedP := sha256.Sum256([]byte(c.Password))
+	return subtle.ConstantTimeCompare(p[:], expectedP[:]) == 1
+}
+
+// BasicAuth accepts any one of a list of credentials. Credentials given to
+// NewBasicAuth are fixed; credentials loaded from a file can be replaced at
+// runtime so that old and new passwords overlap during rotation.
+type BasicAuth struct {
+	static []Credential
+
+	mu       sync.RWMutex
+	fromFile []Credential
+}
+
+// NewBasicAuth accepts the given credentials. Credentials missing a username
+// or a password are ignored.
+func NewBasicAuth(credentials ...Credential) *BasicAuth {
+	static := []Credential{}
+	for _, c := range credentials {
+		if c.Username != "" && c.Password != "" {
+			static = append(static, c)
+		}
+	}
+	return &BasicAuth{static: static}
+}
+
+func (a *BasicAuth) Credentials() []Credential {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append(append([]Credential{}, a.static...), a.fromFile...)
+}
+
+func (a *BasicAuth) Authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	for _, c := range a.Credentials() {
+		if c.matches(username, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFile replaces the file-sourced credentials with the JSON array of
+// credentials found at path.
+func (a *BasicAuth) LoadFile(path string) error {
+	credentials, err := ReadCredentialsFile(path)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.fromFile = credentials
+	a.mu.Unlock()
+	return nil
+}
+
+// WatchFile polls path every interval and reloads it whenever its
+// modification time changes. A file that fails to parse is logged and the
+// previous credentials are kept.
+func (a *BasicAuth) WatchFile(path string, interval time.Duration, logger lager.Logger) {
+	logger = logger.Session("auth-credentials-watch", lager.Data{"path": path})
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Error("stat", err)
+			continue
+		}
+		if info.ModTime().Equal(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		if err := a.LoadFile(path); err != nil {
+			logger.Error("reload", err)
+			continue
+		}
+		logger.Info("reloaded", lager.Data{"count": len(a.Credentials())})
+	}
+}
+
+func ReadCredentialsFile(path string) ([]Credential, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCredentials(data)
+}
+
+func ParseCredentials(data []byte) ([]Credential, error) {
+	credentials := []Credential{}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("parsing credentials: %s", err)
+	}
+	for i, c := range credentials {
+		if c.Username == "" || c.Password == "" {
+			return nil, fmt.Errorf("credential %d is missing a username or password", i)
+		}
+	}
+	return credentials, nil
+}
+
+const minPasswordLength = 12
+
+// WeakUsernames returns the usernames of plain text credentials whose
+// password is short or the same as the username. Hashed passwords
+// cannot be inspected and are never reported.
+func (a *BasicAuth) WeakUsernames() []string {
+	weak := []string{}
+	for _, c := range a.Credentials() {
+		if c.hashed() {
+			continue
+		}
+		if len(c.Password) < minPasswordLength || c.Password == c.Username {
+			weak = append(weak, c.Username)
+		}
+	}
+	return weak
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func authorized(a *BasicAuth, username, password string) bool {
+	r := httptest.NewRequest("GET", "/v2/catalog", nil)
+	r.SetBasicAuth(username, password)
+	return a.Authorized(r)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewBasicAuth(
+		Credential{Username: "broker", Password: "old-password"},
+		Credential{Username: "broker", Password: "new-password"},
+		Credential{Username: "hashed", Password: string(hash)},
+		Credential{Username: "no-password"},
+		Credential{Password: "no-username"},
+	)
+
+	tests := []struct {
+		name               string
+		username, password string
+		authorized         bool
+	}{
+		{"plain", "broker", "old-password", true},
+		{"second plain credential for the same user", "broker", "new-password", true},
+		{"bcrypt", "hashed", "hashed-password", true},
+		{"wrong password", "broker", "wrong-password", false},
+		{"password prefix", "broker", "old-pass", false},
+		{"password of another user", "hashed", "old-password", false},
+		{"bcrypt hash sent as the password", "hashed", string(hash), false},
+		{"unknown user", "someone", "old-password", false},
+		{"username prefix", "brok", "old-password", false},
+		{"empty password", "no-password", "", false},
+		{"empty username", "", "no-username", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := authorized(a, test.username, test.password); got != test.authorized {
+				t.Errorf("Authorized(%q, %q) = %v, expected %v", test.username, test.password, got, test.authorized)
+			}
+		})
+	}
+
+	t.Run("no credentials", func(t *testing.T) {
+		if a.Authorized(httptest.NewRequest("GET", "/v2/catalog", nil)) {
+			t.Error("expected a request without credentials to be rejected")
+		}
+	})
+	t.Run("incomplete credentials are ignored", func(t *testing.T) {
+		if got := len(a.Credentials()); got != 3 {
+			t.Errorf("expected 3 credentials, got %d", got)
+		}
+	})
+}
+
+func TestBasicAuthWatchFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+	a := NewBasicAuth(Credential{Username: "admin", Password: "static-password"})
+
+	// WatchFile only notices changes to the modification time, and reads
+	// the initial one when it starts, so files are rewritten with a later
+	// time until the change shows.
+	modified := time.Now().Add(-time.Hour)
+	write := func(data string) {
+		t.Helper()
+		modified = modified.Add(time.Second)
+		if err := ioutil.WriteFile(file.Name(), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file.Name(), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reload := func(data, username, password string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for authorized(a, username, password) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Authorized(%q, %q) is still %v", username, password, !want)
+			}
+			write(data)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	write(`[{"username": "broker", "password": "old-password"}]`)
+	if err := a.LoadFile(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if !authorized(a, "broker", "old-password") {
+		t.Fatal("expected the credential from the file to be accepted")
+	}
+	go a.WatchFile(file.Name(), 10*time.Millisecond, lager.NewLogger("test"))
+
+	// Rotation: add the new password, then remove the old one.
+	reload(`[{"username": "broker", "password": "old-password"}, {"username": "broker", "password": "new-password"}]`, "broker", "new-password", true)
+	if !authorized(a, "broker", "old-password") {
+		t.Error("expected the old password to be accepted until it is removed")
+	}
+	reload(`[{"username": "broker", "password": "new-password"}]`, "broker", "old-password", false)
+	if !authorized(a, "broker", "new-password") {
+		t.Error("expected the new password to be accepted")
+	}
+
+	// An invalid file keeps the previous credentials.
+	write(`[{"username": "broker"}]`)
+	time.Sleep(100 * time.Millisecond)
+	if !authorized(a, "broker", "new-password") {
+		t.Error("expected an invalid file to keep the previous credentials")
+	}
+	if err := a.LoadFile(file.Name()); err == nil {
+		t.Error("expected an error loading a credential without a password")
+	}
+
+	if !authorized(a, "admin", "static-password") {
+		t.Error("expected static credentials to survive reloads")
+	}
+}
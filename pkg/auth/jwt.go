@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const clockSkew = 30 * time.Second
+
+// JWTConfig configures bearer token validation. Exactly one of JWKSFile or
+// JWKSURL names the key set used to verify token signatures.
+type JWTConfig struct {
+	JWKSFile      string
+	JWKSURL       string
+	Issuer        string
+	Audience      string
+	RequiredScope string
+}
+
+// JWTAuth accepts requests carrying an "Authorization: Bearer" token signed
+// by one of the keys in a JWKS. Keys fetched from a URL are refetched, at
+// most once a minute, when a token names an unknown key ID.
+type JWTAuth struct {
+	Config JWTConfig
+	Logger lager.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastFetched time.Time
+}
+
+func NewJWTAuth(config JWTConfig, logger lager.Logger) (*JWTAuth, error) {
+	if (config.JWKSFile == "") == (config.JWKSURL == "") {
+		return nil, errors.New("exactly one of a JWKS file or JWKS URL is required")
+	}
+	a := &JWTAuth{
+		Config: config,
+		Logger: logger.Session("jwt-auth"),
+	}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *JWTAuth) Authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	if _, err := a.Validate(strings.TrimPrefix(header, "Bearer ")); err != nil {
+		a.Logger.Info("rejected", lager.Data{"reason": err.Error()})
+		return false
+	}
+	return true
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Claims are the registered and scope claims checked by JWTAuth.
+type Claims struct {
+	Issuer    string     `json:"iss"`
+	Subject   string     `json:"sub"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt int64      `json:"exp"`
+	NotBefore int64      `json:"nbf"`
+	Scope     stringList `json:"scope"`
+	Scp       stringList `json:"scp"`
+}
+
+// stringList decodes either a JSON array of strings or a single
+// space-separated string, as used by the "aud" and "scope" claims.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = strings.Fields(single)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (c Claims) scopes() []string {
+	return append(append([]string{}, c.Scope...), c.Scp...)
+}
+
+// Validate verifies the token signature and its exp, nbf, iss, aud and
+// scope claims, returning the claims of a valid token.
+func (a *JWTAuth) Validate(token string) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("decoding header: %s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("decoding signature: %s", err)
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verify(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("decoding claims: %s", err)
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return claims, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return claims, errors.New("token not yet valid")
+	}
+	if a.Config.Issuer != "" && claims.Issuer != a.Config.Issuer {
+		return claims, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.Config.Audience != "" && !contains(claims.Audience, a.Config.Audience) {
+		return claims, fmt.Errorf("token audience does not include %q", a.Config.Audience)
+	}
+	if a.Config.RequiredScope != "" && !contains(claims.scopes(), a.Config.RequiredScope) {
+		return claims, fmt.Errorf("token is missing scope %q", a.Config.RequiredScope)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func verify(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %q", alg)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %q", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+func (a *JWTAuth) key(kid string) (crypto.PublicKey, error) {
+	a.mu.RLock()
+	key, ok := a.lookup(kid)
+	stale := time.Since(a.lastFetched) > time.Minute
+	a.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if a.Config.JWKSURL != "" && stale {
+		if err := a.loadKeys(); err != nil {
+			a.Logger.Error("refresh-jwks", err)
+		}
+		a.mu.RLock()
+		key, ok = a.lookup(kid)
+		a.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// lookup finds a key by ID. A token without a key ID may be verified by the
+// only key of a single-key set.
+func (a *JWTAuth) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (a *JWTAuth) loadKeys() error {
+	var data []byte
+	var err error
+	if a.Config.JWKSFile != "" {
+		data, err = ioutil.ReadFile(a.Config.JWKSFile)
+	} else {
+		data, err = fetch(a.Config.JWKSURL)
+	}
+	if err != nil {
+		return fmt.Errorf("loading JWKS: %s", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.keys = keys
+	a.lastFetched = time.Now()
+	a.mu.Unlock()
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// errUnsupportedKey marks keys that JWTAuth cannot verify with, such as
+// Ed25519 (OKP) or symmetric (oct) keys.
+var errUnsupportedKey = errors.New("unsupported key")
+
+// ParseJWKS returns the RSA and EC signing keys of a JSON Web Key Set,
+// indexed by key ID. Keys of other types or curves are skipped, as identity
+// providers often publish them alongside RSA keys.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %s", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err == errUnsupportedKey {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWKS key %q: %s", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errUnsupportedKey
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+var (
+	rsaKey  = mustRSAKey()
+	ec256   = mustECKey(elliptic.P256())
+	ec384   = mustECKey(elliptic.P384())
+	otherRS = mustRSAKey()
+)
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func mustECKey(curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC", "kid": kid, "use": "sig", "crv": key.Curve.Params().Name,
+		"x": b64(pad(key.X.Bytes(), size)), "y": b64(pad(key.Y.Bytes(), size)),
+	}
+}
+
+func pad(data []byte, size int) []byte {
+	return append(make([]byte, size-len(data)), data...)
+}
+
+func jwks(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign returns a token over header and claims. The signature is made with
+// key according to alg; alg "none" and "HS256" get an arbitrary signature.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	hash := crypto.SHA256
+	switch {
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch alg[:2] {
+	case "RS":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), hash, digest)
+	case "PS":
+		signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		ecKey := key.(*ecdsa.PrivateKey)
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, ecKey, digest)
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		signature = append(pad(r.Bytes(), size), pad(s.Bytes(), size)...)
+	default:
+		signature = digest
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss": "https://uaa.example.com/oauth/token",
+		"sub": "cloud_controller",
+		"aud": "broker",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newTestJWTAuth(t *testing.T, config JWTConfig, keys []byte) *JWTAuth {
+	t.Helper()
+	// File keys are only read here, so the file can go once they are loaded.
+	if config.JWKSURL == "" {
+		file, err := ioutil.TempFile("", "jwks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		if _, err := file.Write(keys); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		config.JWKSFile = file.Name()
+	}
+	a, err := NewJWTAuth(config, lager.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestJWTSignatures(t *testing.T) {
+	a := newTestJWTAuth(t, JWTConfig{}, jwks(t,
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("ec256", &ec256.PublicKey),
+		ecJWK("ec384", &ec384.PublicKey),
+	))
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", sign(t, "RS256", "rsa", rsaKey, validClaims()), true},
+		{"RS512", sign(t, "RS512", "rsa", rsaKey, validClaims()), true},
+		{"PS256", sign(t, "PS256", "rsa", rsaKey, validClaims()), true},
+		{"PS384", sign(t, "PS384", "rsa", rsaKey, validClaims()), true},
+		{"ES256", sign(t, "ES256", "ec256", ec256, validClaims()), true},
+		{"ES384", sign(t, "ES384", "ec384", ec384, validClaims()), true},
+		{"signed by another key", sign(t, "RS256", "rsa", otherRS, validClaims()), false},
+		{"algorithm does not match key", sign(t, "ES256", "rsa", ec256, validClaims()), false},
+		{"alg none", sign(t, "none", "rsa", nil, validClaims()), false},
+		{"HS256", sign(t, "HS256", "rsa", nil, validClaims()), false},
+		{"malformed", "not.a-token", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := a.Validate(test.token)
+			if test.valid && err != nil {
+				t.Errorf("expected a valid token, got %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+
+	t.Run("tampered claims", func(t *testing.T) {
+		parts := strings.Split(sign(t, "RS256", "rsa", rsaKey, validClaims()), ".")
+		claims := validClaims()
+		claims["sub"] = "someone-else"
+		payload, _ := json.Marshal(claims)
+		if _, err := a.Validate(parts[0] + "." + b64(payload) + "." + parts[2]); err == nil {
+			t.Error("expected the token to be rejected")
+		}
+	})
+}
+
+func TestJWTTimes(t *testing.T) {
+	a := newTestJWTAuth(t, JWTConfig{}, jwks(t, rsaJWK("rsa", &rsaKey.PublicKey)))
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		exp   time.Time
+		nbf   time.Time
+		valid bool
+	}{
+		{"expires in the future", now.Add(time.Minute), time.Time{}, true},
+		{"expired within the clock skew", now.Add(-10 * time.Second), time.Time{}, true},
+		{"expired beyond the clock skew", now.Add(-time.Minute), time.Time{}, false},
+		{"no exp", time.Time{}, time.Time{}, false},
+		{"not before in the past", now.Add(time.Minute), now.Add(-time.Minute), true},
+		{"not before within the clock skew", now.Add(time.Minute), now.Add(10 * time.Second), true},
+		{"not before beyond the clock skew", now.Add(time.Hour), now.Add(time.Minute), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims()
+			delete(claims, "exp")
+			if !test.exp.IsZero() {
+				claims["exp"] = test.exp.Unix()
+			}
+			if !test.nbf.IsZero() {
+				claims["nbf"] = test.nbf.Unix()
+			}
+			_, err := a.Validate(sign(t, "RS256", "rsa", rsaKey, claims))
+			if test.valid && err != nil {
+				t.Errorf("expected a valid token, got %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	a := newTestJWTAuth(t, JWTConfig{
+		Issuer:        "https://uaa.example.com/oauth/token",
+		Audience:      "broker",
+		RequiredScope: "broker.admin",
+	}, jwks(t, rsaJWK("rsa", &rsaKey.PublicKey)))
+
+	tests := []struct {
+		name   string
+		change map[string]interface{}
+		valid  bool
+	}{
+		{"string aud and scope", map[string]interface{}{"scope": "openid broker.admin"}, true},
+		{"array aud and scope", map[string]interface{}{"aud": []string{"cloud_controller", "broker"}, "scope": []string{"openid", "broker.admin"}}, true},
+		{"scp claim", map[string]interface{}{"scp": []string{"broker.admin"}}, true},
+		{"wrong issuer", map[string]interface{}{"iss": "https://evil.example.com", "scope": "broker.admin"}, false},
+		{"no issuer", map[string]interface{}{"iss": nil, "scope": "broker.admin"}, false},
+		{"wrong string aud", map[string]interface{}{"aud": "other", "scope": "broker.admin"}, false},
+		{"wrong array aud", map[string]interface{}{"aud": []string{"other", "brokers"}, "scope": "broker.admin"}, false},
+		{"missing string scope", map[string]interface{}{"scope": "openid broker.read"}, false},
+		{"missing array scope", map[string]interface{}{"scope": []string{"openid", "broker"}}, false},
+		{"no scope", map[string]interface{}{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims()
+			for key, value := range test.change {
+				if value == nil {
+					delete(claims, key)
+				} else {
+					claims[key] = value
+				}
+			}
+			_, err := a.Validate(sign(t, "RS256", "rsa", rsaKey, claims))
+			if test.valid && err != nil {
+				t.Errorf("expected a valid token, got %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestJWTAuthorized(t *testing.T) {
+	a := newTestJWTAuth(t, JWTConfig{}, jwks(t, rsaJWK("rsa", &rsaKey.PublicKey)))
+	token := sign(t, "RS256", "rsa", rsaKey, validClaims())
+
+	for header, authorized := range map[string]bool{
+		"Bearer " + token:     true,
+		"bearer" + token:      false,
+		"Basic " + token:      false,
+		"":                    false,
+		"Bearer " + token[1:]: false,
+	} {
+		r := httptest.NewRequest("GET", "/v2/catalog", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := a.Authorized(r); got != authorized {
+			t.Errorf("Authorized with %.20q... = %v, expected %v", header, got, authorized)
+		}
+	}
+}
+
+func TestJWTKeyRefetch(t *testing.T) {
+	var mu sync.Mutex
+	keys := jwks(t, rsaJWK("old", &rsaKey.PublicKey))
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.Write(keys)
+	}))
+	defer server.Close()
+
+	a := newTestJWTAuth(t, JWTConfig{JWKSURL: server.URL}, nil)
+	if _, err := a.Validate(sign(t, "RS256", "old", rsaKey, validClaims())); err != nil {
+		t.Fatalf("expected a valid token, got %s", err)
+	}
+
+	mu.Lock()
+	keys = jwks(t, rsaJWK("old", &rsaKey.PublicKey), rsaJWK("new", &otherRS.PublicKey))
+	mu.Unlock()
+	token := sign(t, "RS256", "new", otherRS, validClaims())
+
+	// Keys were just fetched, so an unknown key ID does not refetch them.
+	if _, err := a.Validate(token); err == nil || !strings.Contains(err.Error(), "unknown key ID") {
+		t.Errorf("expected an unknown key ID error, got %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+
+	a.mu.Lock()
+	a.lastFetched = time.Now().Add(-2 * time.Minute)
+	a.mu.Unlock()
+	if _, err := a.Validate(token); err != nil {
+		t.Errorf("expected a valid token after refetching the keys, got %s", err)
+	}
+	if fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches)
+	}
+
+	if _, err := a.Validate(sign(t, "RS256", "unknown", rsaKey, validClaims())); err == nil {
+		t.Error("expected a token with an unknown key ID to be rejected")
+	}
+	if fetches != 2 {
+		t.Errorf("expected no fetch within a minute of the last one, got %d fetches", fetches)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	okp := map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64([]byte("x"))}
+	oct := map[string]string{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))}
+	secp256k1 := map[string]string{"kty": "EC", "kid": "k1", "crv": "secp256k1", "x": b64([]byte("x")), "y": b64([]byte("y"))}
+	encryption := rsaJWK("enc", &otherRS.PublicKey)
+	encryption["use"] = "enc"
+
+	keys, err := ParseJWKS(jwks(t, okp, oct, secp256k1, encryption, rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ec256.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys["rsa"] == nil || keys["ec"] == nil {
+		t.Errorf("expected the rsa and ec keys, got %v", keys)
+	}
+
+	if _, err := ParseJWKS(jwks(t, okp, oct, encryption)); err == nil {
+		t.Error("expected an error for a JWKS without usable signing keys")
+	}
+
+	malformed := rsaJWK("bad", &rsaKey.PublicKey)
+	malformed["n"] = "not base64!"
+	if _, err := ParseJWKS(jwks(t, malformed, rsaJWK("rsa", &rsaKey.PublicKey))); err == nil {
+		t.Error("expected an error for a malformed RSA key")
+	}
+}
@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// PlatformContext is the part of the OSB "context" object the broker cares
+// about: the CF organization and space, or the Kubernetes namespace and
+// cluster, that a request comes from.
+type PlatformContext struct {
+	Platform         string `json:"platform,omitempty"`
+	OrganizationGUID string `json:"organization_guid,omitempty"`
+	SpaceGUID        string `json:"space_guid,omitempty"`
+	Namespace        string `json:"namespace,omitempty"`
+	ClusterID        string `json:"clusterid,omitempty"`
+}
+
+func parsePlatformContext(rawContext json.RawMessage) PlatformContext {
+	var platformContext PlatformContext
+	if len(rawContext) > 0 {
+		json.Unmarshal(rawContext, &platformContext)
+	}
+	return platformContext
+}
+
+func provisionContext(details brokerapi.ProvisionDetails) PlatformContext {
+	platformContext := parsePlatformContext(details.GetRawContext())
+	if platformContext.OrganizationGUID == "" {
+		platformContext.OrganizationGUID = details.OrganizationGUID
+	}
+	if platformContext.SpaceGUID == "" {
+		platformContext.SpaceGUID = details.SpaceGUID
+	}
+	return platformContext
+}
+
+func updateContext(details brokerapi.UpdateDetails) PlatformContext {
+	platformContext := parsePlatformContext(details.RawContext)
+	if platformContext.OrganizationGUID == "" {
+		platformContext.OrganizationGUID = details.PreviousValues.OrgID
+	}
+	if platformContext.SpaceGUID == "" {
+		platformContext.SpaceGUID = details.PreviousValues.SpaceID
+	}
+	return platformContext
+}
+
+func bindContext(details brokerapi.BindDetails) PlatformContext {
+	platformContext := parsePlatformContext(details.GetRawContext())
+	if platformContext.SpaceGUID == "" && details.BindResource != nil {
+		platformContext.SpaceGUID = details.BindResource.SpaceGuid
+	}
+	return platformContext
+}
+
+// AccessControl restricts which organizations, spaces, namespaces and
+// clusters may provision a plan. A value on a deny list is always refused;
+// when an allow list is non-empty only the values on it are accepted.
+type AccessControl struct {
+	AllowOrgs       []string `json:"allow_orgs,omitempty"`
+	DenyOrgs        []string `json:"deny_orgs,omitempty"`
+	AllowSpaces     []string `json:"allow_spaces,omitempty"`
+	DenySpaces      []string `json:"deny_spaces,omitempty"`
+	AllowNamespaces []string `json:"allow_namespaces,omitempty"`
+	DenyNamespaces  []string `json:"deny_namespaces,omitempty"`
+	AllowClusters   []string `json:"allow_clusters,omitempty"`
+	DenyClusters    []string `json:"deny_clusters,omitempty"`
+}
+
+func (access *AccessControl) check(platformContext PlatformContext) error {
+	if access == nil {
+		return nil
+	}
+	checks := []struct {
+		kind  string
+		value string
+		allow []string
+		deny  []string
+	}{
+		{"organization", platformContext.OrganizationGUID, access.AllowOrgs, access.DenyOrgs},
+		{"space", platformContext.SpaceGUID, access.AllowSpaces, access.DenySpaces},
+		{"namespace", platformContext.Namespace, access.AllowNamespaces, access.DenyNamespaces},
+		{"cluster", platformContext.ClusterID, access.AllowClusters, access.DenyClusters},
+	}
+	for _, c := range checks {
+		if contains(c.deny, c.value) || (len(c.allow) > 0 && !contains(c.allow, c.value)) {
+			if c.value == "" {
+				return fmt.Errorf("plan is restricted by %s and the request did not say which %s it is from", c.kind, c.kind)
+			}
+			return fmt.Errorf("%s %s is not allowed to use this plan", c.kind, c.value)
+		}
+	}
+	return nil
+}
+
+func (bkr *BrokerImpl) checkAccess(plan CatalogPlan, platformContext PlatformContext) error {
+	if err := plan.Access.check(platformContext); err != nil {
+		return brokerapi.NewFailureResponseBuilder(err, http.StatusForbidden, "access-denied").WithErrorKey("Forbidden").Build()
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	// SyncMode operations always complete immediately.
+	SyncMode = "sync"
+	// AsyncMode operations are always asynchronous; platforms that do not
+	// send accepts_incomplete=true get 422 AsyncRequired.
+	AsyncMode = "async"
+	// AsyncWhenAllowedMode operations are asynchronous only for platforms
+	// that send accepts_incomplete=true.
+	AsyncWhenAllowedMode = "async_when_allowed"
+)
+
+func checkAsyncMode(planName, field, mode string) error {
+	switch mode {
+	case SyncMode, AsyncMode, AsyncWhenAllowedMode:
+		return nil
+	}
+	return fmt.Errorf("plan %s has unknown %s %q (expected %q, %q or %q)", planName, field, mode, SyncMode, AsyncMode, AsyncWhenAllowedMode)
+}
+
+// isAsync decides whether an operation in the given mode completes
+// asynchronously, given the platform's accepts_incomplete.
+func isAsync(mode string, asyncAllowed bool) (bool, error) {
+	switch mode {
+	case AsyncMode:
+		if !asyncAllowed {
+			return false, brokerapi.ErrAsyncRequired
+		}
+		return true, nil
+	case AsyncWhenAllowedMode:
+		return asyncAllowed, nil
+	}
+	return false, nil
+}
+
+// instanceAsync and bindingAsync apply the plan's async and async_bindings
+// modes. Unknown plans are synchronous.
+func (bkr *BrokerImpl) instanceAsync(planID string, asyncAllowed bool) (bool, error) {
+	_, plan, _ := bkr.Catalog().FindPlan(planID)
+	return isAsync(plan.Async, asyncAllowed)
+}
+
+func (bkr *BrokerImpl) bindingAsync(planID string, asyncAllowed bool) (bool, error) {
+	_, plan, _ := bkr.Catalog().FindPlan(planID)
+	return isAsync(plan.AsyncBindings, asyncAllowed)
+}
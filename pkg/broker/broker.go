@@ -2,154 +2,412 @@ package broker
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"os"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi"
 )
 
 type BrokerImpl struct {
-	Logger    lager.Logger
-	Config    Config
-	Instances map[string]brokerapi.GetInstanceDetailsSpec
-	Bindings  map[string]brokerapi.GetBindingSpec
+	Logger     lager.Logger
+	Config     Config
+	Instances  map[string]Instance
+	Bindings   map[string]Binding
+	Operations map[string]Operation
+
+	clock       Clock
+	store       Store
+	credentials CredentialProvider
+
+	mu        sync.RWMutex
+	catalogMu sync.RWMutex
 }
 
+// Config is the broker's configuration. The worlds-simplest-service-broker
+// command fills it in from environment variables.
 type Config struct {
 	ServiceName    string
 	ServicePlan    string
 	BaseGUID       string
+	IDMode         string
 	Credentials    interface{}
 	Tags           string
 	ImageURL       string
 	SysLogDrainURL string
-	Free           bool
+	// Paid marks plans without costs as not free; plans are free unless
+	// they set free or costs.
+	Paid bool
+
+	FakeAsync         bool
+	FakeStateful      bool
+	RetryAfter        time.Duration
+	OperationDuration time.Duration
+
+	BindingCredentials   string
+	RouteServiceProxyURL string
 
-	FakeAsync    bool
-	FakeStateful bool
+	ServiceDescription  string
+	LongDescription     string
+	ProviderDisplayName string
+	DocumentationURL    string
+	SupportURL          string
+
+	Catalog     Catalog
+	CatalogFile string
+	Dashboard   DashboardConfig
 }
 
-func NewBrokerImpl(logger lager.Logger) (bkr *BrokerImpl) {
-	var credentials interface{}
-	json.Unmarshal([]byte(getEnvWithDefault("CREDENTIALS", "{\"port\": \"4000\"}")), &credentials)
-	fmt.Printf("Credentials: %v\n", credentials)
-
-	return &BrokerImpl{
-		Logger:    logger,
-		Instances: map[string]brokerapi.GetInstanceDetailsSpec{},
-		Bindings:  map[string]brokerapi.GetBindingSpec{},
-		Config: Config{
-			BaseGUID:    getEnvWithDefault("BASE_GUID", "29140B3F-0E69-4C7E-8A35"),
-			ServiceName: getEnvWithDefault("SERVICE_NAME", "some-service-name"),
-			ServicePlan: getEnvWithDefault("SERVICE_PLAN_NAME", "shared"),
-			Credentials: credentials,
-			Tags:        getEnvWithDefault("TAGS", "shared,worlds-simplest-service-broker"),
-			ImageURL:    os.Getenv("IMAGE_URL"),
-			Free:        true,
-
-			FakeAsync:    os.Getenv("FAKE_ASYNC") == "true",
-			FakeStateful: os.Getenv("FAKE_STATEFUL") == "true",
-		},
+// New returns a broker for config. The catalog is config.Catalog, or a
+// single service and plan built from ServiceName, ServicePlan and
+// Credentials when it has no services; either way it is validated and
+// missing IDs, descriptions and credentials are filled in.
+func New(config Config, options ...Option) (*BrokerImpl, error) {
+	bkr := &BrokerImpl{
+		Logger:     lager.NewLogger("worlds-simplest-service-broker"),
+		Instances:  map[string]Instance{},
+		Bindings:   map[string]Binding{},
+		Operations: map[string]Operation{},
+		clock:      systemClock{},
 	}
-}
+	for _, option := range options {
+		option(bkr)
+	}
+
+	if config.IDMode == "" {
+		config.IDMode = LegacyIDs
+	}
+	if config.BindingCredentials == "" {
+		config.BindingCredentials = SnapshotCredentials
+	}
+	if config.Dashboard.URL != "" {
+		if len(config.Dashboard.Secret) == 0 {
+			config.Dashboard.Secret = make([]byte, 32)
+			rand.Read(config.Dashboard.Secret)
+			bkr.Logger.Info("dashboard-secret-generated", lager.Data{"note": "dashboard URLs will stop working when the broker restarts; set DASHBOARD_SECRET"})
+		}
+		if config.Dashboard.TokenTTL == 0 {
+			config.Dashboard.TokenTTL = 24 * time.Hour
+		}
+	}
+
+	catalog := config.Catalog
+	if len(catalog.Services) == 0 {
+		catalog = defaultCatalog(config)
+	}
+	catalog, err := catalog.withDefaults(config)
+	if err != nil {
+		return nil, err
+	}
+	config.Catalog = catalog
+	bkr.Config = config
 
-func getEnvWithDefault(key, defaultValue string) string {
-	if os.Getenv(key) == "" {
-		return defaultValue
+	if bkr.store != nil {
+		state, err := bkr.store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("loading state: %s", err)
+		}
+		bkr.setState(state)
 	}
-	return os.Getenv(key)
+	return bkr, nil
 }
 
 func (bkr *BrokerImpl) Services(ctx context.Context) ([]brokerapi.Service, error) {
-	return []brokerapi.Service{
-		brokerapi.Service{
-			ID:                   bkr.Config.BaseGUID + "-service-" + bkr.Config.ServiceName,
-			Name:                 bkr.Config.ServiceName,
-			Description:          "Shared service for " + bkr.Config.ServiceName,
-			Bindable:             true,
+	withMaintenanceInfo := APIVersionFromContext(ctx).AtLeast(2, 15)
+	services := []brokerapi.Service{}
+	for _, service := range bkr.Catalog().Services {
+		plans := []brokerapi.ServicePlan{}
+		for _, plan := range service.Plans {
+			servicePlan := brokerapi.ServicePlan{
+				ID:          plan.ID,
+				Name:        plan.Name,
+				Description: plan.Description,
+				Free:        plan.Free,
+				Bindable:    plan.Bindable,
+			}
+			if plan.DisplayName != "" || len(plan.Bullets) > 0 || len(plan.Costs) > 0 {
+				servicePlan.Metadata = &brokerapi.ServicePlanMetadata{
+					DisplayName: plan.DisplayName,
+					Bullets:     plan.Bullets,
+					Costs:       plan.Costs,
+				}
+			}
+			if withMaintenanceInfo {
+				servicePlan.MaintenanceInfo = plan.MaintenanceInfo
+			}
+			plans = append(plans, servicePlan)
+		}
+		services = append(services, brokerapi.Service{
+			ID:                   service.ID,
+			Name:                 service.Name,
+			Description:          service.Description,
+			Bindable:             *service.Bindable,
+			Tags:                 service.Tags,
 			InstancesRetrievable: bkr.Config.FakeStateful,
 			BindingsRetrievable:  bkr.Config.FakeStateful,
+			PlanUpdatable:        service.PlanUpdatable,
+			Requires:             service.requires(),
 			Metadata: &brokerapi.ServiceMetadata{
-				DisplayName: bkr.Config.ServiceName,
-				ImageUrl:    bkr.Config.ImageURL,
-			},
-			Plans: []brokerapi.ServicePlan{
-				brokerapi.ServicePlan{
-					ID:          bkr.Config.BaseGUID + "-plan-" + bkr.Config.ServicePlan,
-					Name:        bkr.Config.ServicePlan,
-					Description: "Shared service for " + bkr.Config.ServiceName,
-					Free:        &bkr.Config.Free,
-				},
+				DisplayName:         service.DisplayName,
+				ImageUrl:            service.ImageURL,
+				LongDescription:     service.LongDescription,
+				ProviderDisplayName: service.ProviderDisplayName,
+				DocumentationUrl:    service.DocumentationURL,
+				SupportUrl:          service.SupportURL,
+				Shareable:           service.Shareable,
 			},
-		},
-	}, nil
+			Plans: plans,
+		})
+	}
+	return services, nil
 }
 
 func (bkr *BrokerImpl) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+	_, plan, ok := bkr.Catalog().FindPlan(details.PlanID)
+	if !ok {
+		return brokerapi.ProvisionedServiceSpec{}, brokerapi.NewFailureResponse(fmt.Errorf("Unknown plan ID %s", details.PlanID), 400, "provision")
+	}
+	platformContext := provisionContext(details)
+	if err := bkr.checkAccess(plan, platformContext); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if err := checkMaintenanceInfo(ctx, plan, details.MaintenanceInfo); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	async, err := isAsync(plan.Async, asyncAllowed)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
 	var parameters interface{}
 	json.Unmarshal(details.GetRawParameters(), &parameters)
-	bkr.Instances[instanceID] = brokerapi.GetInstanceDetailsSpec{
+
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	if existing, exists := bkr.Instances[instanceID]; exists {
+		if existing.ServiceID != details.ServiceID || existing.PlanID != details.PlanID || !reflect.DeepEqual(existing.Parameters, parameters) {
+			return brokerapi.ProvisionedServiceSpec{}, brokerapi.ErrInstanceAlreadyExists
+		}
+		spec := brokerapi.ProvisionedServiceSpec{DashboardURL: bkr.dashboardURL(instanceID)}
+		if op, found, _ := bkr.findOperation(instanceID, "", ""); found && bkr.creating(instanceID, "") {
+			spec.IsAsync, spec.OperationData = true, op.ID
+		} else {
+			spec.AlreadyExists = true
+		}
+		return spec, nil
+	}
+	if err := bkr.checkInstanceQuota(plan, platformContext); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	instance := Instance{
+		ID:         instanceID,
 		ServiceID:  details.ServiceID,
 		PlanID:     details.PlanID,
 		Parameters: parameters,
+		Context:    platformContext,
+		CreatedAt:  bkr.clock.Now(),
 	}
-	return brokerapi.ProvisionedServiceSpec{
-		IsAsync: bkr.Config.FakeAsync,
-	}, nil
+	instance.pinPlanVersion(plan)
+	bkr.Instances[instanceID] = instance
+	bkr.save()
+	spec := brokerapi.ProvisionedServiceSpec{
+		IsAsync:      async,
+		DashboardURL: bkr.dashboardURL(instanceID),
+	}
+	if async {
+		spec.OperationData = bkr.startOperation("provision", instanceID, "", plan.ID)
+	}
+	return spec, nil
 }
 
 func (bkr *BrokerImpl) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
-	return brokerapi.DeprovisionServiceSpec{
-		IsAsync: bkr.Config.FakeAsync,
-	}, nil
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	instance, ok := bkr.Instances[instanceID]
+	if !ok {
+		return brokerapi.DeprovisionServiceSpec{}, brokerapi.ErrInstanceDoesNotExist
+	}
+	planID := instance.PlanID
+	async, err := bkr.instanceAsync(planID, asyncAllowed)
+	if err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+	bkr.deleteInstance(instanceID)
+	bkr.save()
+	spec := brokerapi.DeprovisionServiceSpec{
+		IsAsync: async,
+	}
+	if async {
+		spec.OperationData = bkr.startOperation("deprovision", instanceID, "", planID)
+	}
+	return spec, nil
 }
 
 func (bkr *BrokerImpl) GetInstance(ctx context.Context, instanceID string) (spec brokerapi.GetInstanceDetailsSpec, err error) {
-	if val, ok := bkr.Instances[instanceID]; ok {
-		return val, nil
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	if val, ok := bkr.Instances[instanceID]; ok && !bkr.creating(instanceID, "") {
+		spec = val.spec()
+		spec.DashboardURL = bkr.dashboardURL(instanceID)
+		return spec, nil
 	}
 	err = brokerapi.NewFailureResponse(fmt.Errorf("Unknown instance ID %s", instanceID), 404, "get-instance")
 	return
 }
 
 func (bkr *BrokerImpl) Bind(ctx context.Context, instanceID string, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (brokerapi.Binding, error) {
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+
+	planID := details.PlanID
+	platformContext := bindContext(details)
+	var shared bool
+	if instance, ok := bkr.Instances[instanceID]; ok {
+		planID = instance.PlanID
+		shared = instance.Context.SpaceGUID != "" && platformContext.SpaceGUID != "" && platformContext.SpaceGUID != instance.Context.SpaceGUID
+	}
+	if shared {
+		bkr.Logger.Info("bind-shared-instance", lager.Data{"instance-id": instanceID, "binding-id": bindingID, "space-guid": platformContext.SpaceGUID})
+	}
+	service, plan, ok := bkr.Catalog().FindPlan(planID)
+	if ok {
+		if !plan.bindable(service) {
+			return brokerapi.Binding{}, brokerapi.NewFailureResponse(fmt.Errorf("Plan %s is not bindable", plan.Name), http.StatusBadRequest, "bind")
+		}
+	}
 	var parameters interface{}
 	json.Unmarshal(details.GetRawParameters(), &parameters)
-	bkr.Bindings[bindingID] = brokerapi.GetBindingSpec{
-		Credentials: bkr.Config.Credentials,
-		Parameters:  parameters,
+	if existing, exists := bkr.Bindings[bindingID]; exists {
+		if existing.InstanceID != instanceID || !reflect.DeepEqual(existing.Parameters, parameters) {
+			return brokerapi.Binding{}, brokerapi.ErrBindingAlreadyExists
+		}
+		binding := brokerapi.Binding{
+			Credentials:     existing.Credentials,
+			RouteServiceURL: existing.RouteServiceURL,
+			VolumeMounts:    existing.VolumeMounts,
+		}
+		if op, found, _ := bkr.findOperation(instanceID, bindingID, ""); found && bkr.creating(instanceID, bindingID) {
+			binding.IsAsync, binding.OperationData = true, op.ID
+		} else {
+			binding.AlreadyExists = true
+		}
+		return binding, nil
+	}
+	if ok {
+		if err := bkr.checkBindingQuota(plan, instanceID); err != nil {
+			return brokerapi.Binding{}, err
+		}
+	}
+	async, err := isAsync(plan.AsyncBindings, asyncAllowed)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+	var credentials interface{}
+	var route, routeServiceURL string
+	if plan.RouteService != nil {
+		if details.BindResource != nil {
+			route = details.BindResource.Route
+		}
+		routeServiceURL = bkr.routeServiceURL(plan, instanceID, bindingID, route)
+	} else {
+		if credentials, err = bkr.currentCredentials(instanceID, bindingID, planID); err != nil {
+			return brokerapi.Binding{}, err
+		}
+	}
+
+	bkr.Bindings[bindingID] = Binding{
+		ID:              bindingID,
+		InstanceID:      instanceID,
+		PlanID:          planID,
+		Credentials:     credentials,
+		RouteServiceURL: routeServiceURL,
+		Route:           route,
+		VolumeMounts:    plan.VolumeMounts,
+		Parameters:      parameters,
+		Context:         platformContext,
+		Shared:          shared,
+		CreatedAt:       bkr.clock.Now(),
+	}
+	bkr.save()
+	binding := brokerapi.Binding{
+		IsAsync:         async,
+		Credentials:     credentials,
+		RouteServiceURL: routeServiceURL,
+		VolumeMounts:    plan.VolumeMounts,
 	}
-	return brokerapi.Binding{
-		Credentials: bkr.Config.Credentials,
-	}, nil
+	if async {
+		binding.OperationData = bkr.startOperation("bind", instanceID, bindingID, planID)
+	}
+	return binding, nil
 }
 
 func (bkr *BrokerImpl) Unbind(ctx context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (brokerapi.UnbindSpec, error) {
-	return brokerapi.UnbindSpec{}, nil
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	binding, ok := bkr.Bindings[bindingID]
+	if !ok || binding.InstanceID != instanceID {
+		return brokerapi.UnbindSpec{}, brokerapi.ErrBindingDoesNotExist
+	}
+	planID := binding.PlanID
+	async, err := bkr.bindingAsync(planID, asyncAllowed)
+	if err != nil {
+		return brokerapi.UnbindSpec{}, err
+	}
+	delete(bkr.Bindings, bindingID)
+	bkr.save()
+	spec := brokerapi.UnbindSpec{
+		IsAsync: async,
+	}
+	if async {
+		spec.OperationData = bkr.startOperation("unbind", instanceID, bindingID, planID)
+	}
+	return spec, nil
 }
 
 func (bkr *BrokerImpl) GetBinding(ctx context.Context, instanceID string, bindingID string) (spec brokerapi.GetBindingSpec, err error) {
-	if val, ok := bkr.Bindings[bindingID]; ok {
-		return val, nil
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	if val, ok := bkr.Bindings[bindingID]; ok && !bkr.creating(val.InstanceID, bindingID) {
+		spec = val.spec()
+		if bkr.credentialsMode(val.PlanID) == LiveCredentials {
+			if spec.Credentials, err = bkr.currentCredentials(val.InstanceID, bindingID, val.PlanID); err != nil {
+				return spec, err
+			}
+		}
+		return spec, nil
 	}
 	err = brokerapi.NewFailureResponse(fmt.Errorf("Unknown binding ID %s", bindingID), 404, "get-binding")
 	return
 }
 
 func (bkr *BrokerImpl) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	return brokerapi.UpdateServiceSpec{
-		IsAsync: bkr.Config.FakeAsync,
-	}, nil
-}
-
-func (bkr *BrokerImpl) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
-	return brokerapi.LastOperation{
-		State: brokerapi.Succeeded,
-	}, nil
-}
-
-func (bkr *BrokerImpl) LastBindingOperation(ctx context.Context, instanceID string, bindingID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
-	panic("not implemented")
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	planID := details.PlanID
+	if planID == "" {
+		planID = details.PreviousValues.PlanID
+		if instance, ok := bkr.Instances[instanceID]; ok {
+			planID = instance.PlanID
+		}
+	}
+	async, err := bkr.instanceAsync(planID, asyncAllowed)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+	if err := bkr.updateInstance(ctx, instanceID, details); err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+	spec := brokerapi.UpdateServiceSpec{
+		IsAsync:      async,
+		DashboardURL: bkr.dashboardURL(instanceID),
+	}
+	if async {
+		spec.OperationData = bkr.startOperation("update", instanceID, "", planID)
+	}
+	return spec, nil
 }
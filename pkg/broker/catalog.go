@@ -0,0 +1,324 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Catalog describes the services and plans offered by the broker. It is read
+// from CATALOG_FILE, or built from SERVICE_NAME, SERVICE_PLAN_NAME and
+// CREDENTIALS when no file is given.
+type Catalog struct {
+	Services []CatalogService `json:"services"`
+}
+
+type CatalogService struct {
+	ID            string        `json:"id,omitempty"`
+	Name          string        `json:"name"`
+	Description   string        `json:"description,omitempty"`
+	ImageURL      string        `json:"image_url,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	PlanUpdatable bool          `json:"plan_updateable,omitempty"`
+	Shareable     *bool         `json:"shareable,omitempty"`
+	Plans         []CatalogPlan `json:"plans"`
+
+	DisplayName         string                         `json:"display_name,omitempty"`
+	LongDescription     string                         `json:"long_description,omitempty"`
+	ProviderDisplayName string                         `json:"provider_display_name,omitempty"`
+	DocumentationURL    string                         `json:"documentation_url,omitempty"`
+	SupportURL          string                         `json:"support_url,omitempty"`
+	Bindable            *bool                          `json:"bindable,omitempty"`
+	Requires            []brokerapi.RequiredPermission `json:"requires,omitempty"`
+}
+
+type CatalogPlan struct {
+	ID          string         `json:"id,omitempty"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Free        *bool          `json:"free,omitempty"`
+	Credentials interface{}    `json:"credentials,omitempty"`
+	Access      *AccessControl `json:"access,omitempty"`
+	Quota       *Quota         `json:"quota,omitempty"`
+	UpdatableTo []string       `json:"updatable_to,omitempty"`
+
+	BindingCredentials string                  `json:"binding_credentials,omitempty"`
+	ServiceBinding     *ServiceBindingConfig   `json:"service_binding,omitempty"`
+	RouteService       *RouteServiceConfig     `json:"route_service,omitempty"`
+	VolumeMounts       []brokerapi.VolumeMount `json:"volume_mounts,omitempty"`
+
+	Async         string `json:"async,omitempty"`
+	AsyncBindings string `json:"async_bindings,omitempty"`
+
+	PlanUpdatable          *bool                      `json:"plan_updateable,omitempty"`
+	MaintenanceInfo        *brokerapi.MaintenanceInfo `json:"maintenance_info,omitempty"`
+	MaximumPollingDuration int                        `json:"maximum_polling_duration,omitempty"`
+	RetryAfter             int                        `json:"retry_after,omitempty"`
+
+	DisplayName string                         `json:"display_name,omitempty"`
+	Bullets     []string                       `json:"bullets,omitempty"`
+	Costs       []brokerapi.ServicePlanCost    `json:"costs,omitempty"`
+	Bindable    *bool                          `json:"bindable,omitempty"`
+	Requires    []brokerapi.RequiredPermission `json:"requires,omitempty"`
+}
+
+func LoadCatalogFile(path string) (Catalog, error) {
+	var catalog Catalog
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return catalog, err
+	}
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return catalog, fmt.Errorf("parsing catalog %s: %s", path, err)
+	}
+	return catalog, nil
+}
+
+func defaultCatalog(config Config) Catalog {
+	return Catalog{
+		Services: []CatalogService{
+			{
+				Name:                config.ServiceName,
+				Description:         config.ServiceDescription,
+				ImageURL:            config.ImageURL,
+				LongDescription:     config.LongDescription,
+				ProviderDisplayName: config.ProviderDisplayName,
+				DocumentationURL:    config.DocumentationURL,
+				SupportURL:          config.SupportURL,
+				Plans: []CatalogPlan{
+					{Name: config.ServicePlan},
+				},
+			},
+		},
+	}
+}
+
+// withDefaults fills in IDs, descriptions and credentials left out of the
+// catalog, and checks that names and IDs are unique.
+func (catalog Catalog) withDefaults(config Config) (Catalog, error) {
+	if len(catalog.Services) == 0 {
+		return catalog, fmt.Errorf("catalog has no services")
+	}
+	if err := checkIDMode(config.IDMode); err != nil {
+		return catalog, err
+	}
+	ids := map[string]bool{}
+	serviceNames := map[string]bool{}
+	services := []CatalogService{}
+	for _, service := range catalog.Services {
+		if service.Name == "" {
+			return catalog, fmt.Errorf("catalog service is missing a name")
+		}
+		if serviceNames[service.Name] {
+			return catalog, fmt.Errorf("duplicate service name %s", service.Name)
+		}
+		serviceNames[service.Name] = true
+		if service.ID == "" {
+			service.ID = serviceID(config, service.Name)
+		}
+		if service.Description == "" {
+			service.Description = "Shared service for " + service.Name
+		}
+		if service.Tags == nil {
+			service.Tags = splitTags(config.Tags)
+		}
+		if service.DisplayName == "" {
+			service.DisplayName = service.Name
+		}
+		if service.Bindable == nil {
+			bindable := true
+			service.Bindable = &bindable
+		}
+		if err := checkPermissions(service.Name, service.Requires); err != nil {
+			return catalog, err
+		}
+		if service.Shareable == nil {
+			shareable := true
+			service.Shareable = &shareable
+		}
+		if len(service.Plans) == 0 {
+			return catalog, fmt.Errorf("service %s has no plans", service.Name)
+		}
+		if ids[service.ID] {
+			return catalog, fmt.Errorf("duplicate service ID %s", service.ID)
+		}
+		ids[service.ID] = true
+
+		planNames := map[string]bool{}
+		plans := []CatalogPlan{}
+		for _, plan := range service.Plans {
+			if plan.Name == "" {
+				return catalog, fmt.Errorf("service %s has a plan without a name", service.Name)
+			}
+			if planNames[plan.Name] {
+				return catalog, fmt.Errorf("service %s has more than one plan named %s", service.Name, plan.Name)
+			}
+			planNames[plan.Name] = true
+			if plan.ID == "" {
+				plan.ID = planID(config, service.Name, plan.Name)
+			}
+			if plan.Description == "" {
+				plan.Description = service.Description
+			}
+			if plan.Free == nil {
+				free := !config.Paid && !plan.hasCosts()
+				plan.Free = &free
+			}
+			if *plan.Free && plan.hasCosts() {
+				return catalog, fmt.Errorf("plan %s is free but has costs", plan.Name)
+			}
+			if err := checkPermissions(plan.Name, plan.Requires); err != nil {
+				return catalog, err
+			}
+			if plan.Credentials == nil {
+				plan.Credentials = config.Credentials
+			}
+			if plan.BindingCredentials == "" {
+				plan.BindingCredentials = config.BindingCredentials
+			}
+			if plan.BindingCredentials != SnapshotCredentials && plan.BindingCredentials != LiveCredentials {
+				return catalog, fmt.Errorf("plan %s has unknown binding_credentials %q (expected %q or %q)", plan.Name, plan.BindingCredentials, SnapshotCredentials, LiveCredentials)
+			}
+			if plan.Async == "" {
+				plan.Async = SyncMode
+				if config.FakeAsync {
+					plan.Async = AsyncWhenAllowedMode
+				}
+			}
+			if plan.AsyncBindings == "" {
+				plan.AsyncBindings = SyncMode
+			}
+			if err := checkAsyncMode(plan.Name, "async", plan.Async); err != nil {
+				return catalog, err
+			}
+			if err := checkAsyncMode(plan.Name, "async_bindings", plan.AsyncBindings); err != nil {
+				return catalog, err
+			}
+			if plan.ServiceBinding != nil {
+				serviceBinding := *plan.ServiceBinding
+				if serviceBinding.Type == "" {
+					serviceBinding.Type = service.Name
+				}
+				if serviceBinding.Provider == "" {
+					serviceBinding.Provider = service.ProviderDisplayName
+				}
+				plan.ServiceBinding = &serviceBinding
+				if plan.RouteService != nil {
+					return catalog, fmt.Errorf("plan %s is a route service, its bindings have no credentials to shape for servicebinding.io", plan.Name)
+				}
+				if _, err := ServiceBindingEntries(plan.Credentials, serviceBinding.Type, serviceBinding.Provider); err != nil {
+					return catalog, fmt.Errorf("plan %s: %s", plan.Name, err)
+				}
+			}
+			if plan.RouteService != nil && plan.RouteService.URL == "" && config.RouteServiceProxyURL == "" {
+				return catalog, fmt.Errorf("plan %s is a route service without a url; set one or set ROUTE_SERVICE_PROXY_URL", plan.Name)
+			}
+			if err := plan.validateVolumeMounts(); err != nil {
+				return catalog, err
+			}
+			if ids[plan.ID] {
+				return catalog, fmt.Errorf("duplicate plan ID %s (set an explicit plan id)", plan.ID)
+			}
+			ids[plan.ID] = true
+			plans = append(plans, plan)
+		}
+		for _, plan := range plans {
+			for _, name := range plan.UpdatableTo {
+				if !service.hasPlanNamed(name) {
+					return catalog, fmt.Errorf("plan %s of service %s is updatable to unknown plan %s", plan.Name, service.Name, name)
+				}
+			}
+		}
+		service.Plans = plans
+		services = append(services, service)
+	}
+	return Catalog{Services: services}, nil
+}
+
+func splitTags(tags string) []string {
+	var split []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			split = append(split, tag)
+		}
+	}
+	return split
+}
+
+func (plan CatalogPlan) hasCosts() bool {
+	for _, cost := range plan.Costs {
+		for _, amount := range cost.Amount {
+			if amount > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (plan CatalogPlan) bindable(service CatalogService) bool {
+	if plan.Bindable != nil {
+		return *plan.Bindable
+	}
+	return service.Bindable == nil || *service.Bindable
+}
+
+func checkPermissions(name string, permissions []brokerapi.RequiredPermission) error {
+	for _, permission := range permissions {
+		switch permission {
+		case brokerapi.PermissionRouteForwarding, brokerapi.PermissionSyslogDrain, brokerapi.PermissionVolumeMount:
+		default:
+			return fmt.Errorf("%s requires unknown permission %q", name, permission)
+		}
+	}
+	return nil
+}
+
+// requires lists the permissions the service and its plans need from the
+// platform: those configured on either, plus route_forwarding for route
+// service plans and volume_mount for volume service plans.
+func (service CatalogService) requires() []brokerapi.RequiredPermission {
+	permissions := append([]brokerapi.RequiredPermission{}, service.Requires...)
+	for _, plan := range service.Plans {
+		permissions = append(permissions, plan.Requires...)
+		if plan.RouteService != nil {
+			permissions = append(permissions, brokerapi.PermissionRouteForwarding)
+		}
+		if len(plan.VolumeMounts) > 0 {
+			permissions = append(permissions, brokerapi.PermissionVolumeMount)
+		}
+	}
+	var requires []brokerapi.RequiredPermission
+	seen := map[brokerapi.RequiredPermission]bool{}
+	for _, permission := range permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			requires = append(requires, permission)
+		}
+	}
+	return requires
+}
+
+func (service CatalogService) hasPlanNamed(name string) bool {
+	for _, plan := range service.Plans {
+		if plan.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPlan returns the service and plan with the given plan ID.
+func (catalog Catalog) FindPlan(planID string) (CatalogService, CatalogPlan, bool) {
+	for _, service := range catalog.Services {
+		for _, plan := range service.Plans {
+			if plan.ID == planID {
+				return service, plan, true
+			}
+		}
+	}
+	return CatalogService{}, CatalogPlan{}, false
+}
@@ -0,0 +1,55 @@
+package broker_test
+
+import (
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+func TestCatalogUniqueness(t *testing.T) {
+	plan := func(name string) broker.CatalogPlan {
+		return broker.CatalogPlan{Name: name}
+	}
+	tests := []struct {
+		name     string
+		services []broker.CatalogService
+		err      string
+	}{
+		{"unique names", []broker.CatalogService{
+			{Name: "db", Plans: []broker.CatalogPlan{plan("small"), plan("large")}},
+			{Name: "cache", Plans: []broker.CatalogPlan{plan("tiny")}},
+		}, ""},
+		{"plan name repeated in another service", []broker.CatalogService{
+			{Name: "db", Plans: []broker.CatalogPlan{plan("shared")}},
+			{Name: "cache", Plans: []broker.CatalogPlan{{ID: "cache-shared", Name: "shared"}}},
+		}, ""},
+		{"duplicate service name", []broker.CatalogService{
+			{Name: "db", Plans: []broker.CatalogPlan{plan("small")}},
+			{ID: "other-db", Name: "db", Plans: []broker.CatalogPlan{{ID: "other-small", Name: "small"}}},
+		}, "duplicate service name db"},
+		{"duplicate plan name", []broker.CatalogService{
+			{Name: "db", Plans: []broker.CatalogPlan{plan("small"), {ID: "other-small", Name: "small"}}},
+		}, "service db has more than one plan named small"},
+		{"duplicate plan ID", []broker.CatalogService{
+			{Name: "db", Plans: []broker.CatalogPlan{plan("shared")}},
+			{Name: "cache", Plans: []broker.CatalogPlan{plan("shared")}},
+		}, "duplicate plan ID"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := broker.New(broker.Config{
+				BaseGUID: "29140B3F-0E69-4C7E-8A35",
+				Catalog:  broker.Catalog{Services: test.services},
+			}, broker.WithLogger(lager.NewLogger("test")))
+			if test.err == "" && err != nil {
+				t.Errorf("expected the catalog to load, got %s", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
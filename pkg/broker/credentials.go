@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"reflect"
+)
+
+const (
+	// SnapshotCredentials bindings keep the credentials they were given at
+	// bind time. This is the default.
+	SnapshotCredentials = "snapshot"
+	// LiveCredentials bindings always report the current credentials.
+	LiveCredentials = "live"
+)
+
+// currentCredentials returns the credentials the binding of the instance
+// would receive if it were made now. Callers hold bkr.mu.
+func (bkr *BrokerImpl) currentCredentials(instanceID, bindingID, planID string) (interface{}, error) {
+	instance, knownInstance := bkr.Instances[instanceID]
+	if knownInstance {
+		planID = instance.PlanID
+	} else {
+		instance = Instance{ID: instanceID, PlanID: planID}
+	}
+	_, plan, ok := bkr.Catalog().FindPlan(planID)
+	var credentials interface{}
+	switch {
+	case bkr.credentials != nil:
+		var err error
+		if credentials, err = bkr.credentials.Credentials(instance, bindingID, plan); err != nil {
+			return nil, err
+		}
+	case !ok:
+		return bkr.Config.Credentials, nil
+	case knownInstance && instance.Credentials != nil:
+		credentials = instance.Credentials
+	default:
+		credentials = plan.Credentials
+	}
+	if ok && plan.ServiceBinding != nil {
+		return plan.ServiceBinding.credentials(credentials)
+	}
+	return credentials, nil
+}
+
+// InstanceCredentials returns the credentials a new binding of the instance
+// would receive.
+func (bkr *BrokerImpl) InstanceCredentials(instanceID string) (interface{}, error) {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	return bkr.currentCredentials(instanceID, "", "")
+}
+
+// credentialsMode is empty for route service plans, whose bindings have no
+// credentials.
+func (bkr *BrokerImpl) credentialsMode(planID string) string {
+	if _, plan, ok := bkr.Catalog().FindPlan(planID); ok {
+		if plan.RouteService != nil {
+			return ""
+		}
+		return plan.BindingCredentials
+	}
+	return SnapshotCredentials
+}
+
+// StaleBindings returns the snapshot-mode bindings whose credentials differ
+// from those a new binding would get. Apps using them need to rebind.
+func (bkr *BrokerImpl) StaleBindings() []Binding {
+	stale := []Binding{}
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	for _, binding := range bkr.Bindings {
+		if bkr.credentialsMode(binding.PlanID) != SnapshotCredentials {
+			continue
+		}
+		credentials, err := bkr.currentCredentials(binding.InstanceID, binding.ID, binding.PlanID)
+		if err == nil && !reflect.DeepEqual(binding.Credentials, credentials) {
+			stale = append(stale, binding)
+		}
+	}
+	return stale
+}
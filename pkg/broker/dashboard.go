@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DashboardConfig enables a dashboard_url for every instance. URL is the
+// externally reachable base URL of the broker; tokens in dashboard URLs are
+// signed with Secret and expire after TokenTTL.
+type DashboardConfig struct {
+	URL      string
+	Secret   []byte
+	TokenTTL time.Duration
+}
+
+func (bkr *BrokerImpl) dashboardURL(instanceID string) string {
+	dashboard := bkr.Config.Dashboard
+	if dashboard.URL == "" {
+		return ""
+	}
+	expires := bkr.clock.Now().Add(dashboard.TokenTTL)
+	return fmt.Sprintf("%s/dashboard/%s?token=%s",
+		strings.TrimRight(dashboard.URL, "/"), url.PathEscape(instanceID), url.QueryEscape(bkr.DashboardToken(instanceID, expires)))
+}
+
+func (bkr *BrokerImpl) dashboardSignature(instanceID string, expires int64) string {
+	mac := hmac.New(sha256.New, bkr.Config.Dashboard.Secret)
+	fmt.Fprintf(mac, "%s\n%d", instanceID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DashboardToken returns a token granting access to an instance's dashboard
+// until expires.
+func (bkr *BrokerImpl) DashboardToken(instanceID string, expires time.Time) string {
+	return fmt.Sprintf("%d.%s", expires.Unix(), bkr.dashboardSignature(instanceID, expires.Unix()))
+}
+
+// VerifyDashboardToken checks a token from a dashboard URL. Without a
+// secret every token is refused, as anyone could sign one.
+func (bkr *BrokerImpl) VerifyDashboardToken(instanceID, token string) error {
+	if len(bkr.Config.Dashboard.Secret) == 0 {
+		return errors.New("dashboard is not enabled")
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed dashboard token")
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("malformed dashboard token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(bkr.dashboardSignature(instanceID, expires))) {
+		return errors.New("invalid dashboard token")
+	}
+	if bkr.clock.Now().After(time.Unix(expires, 0)) {
+		return errors.New("dashboard token expired; open the dashboard again from your platform")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package broker_test
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newDashboardBroker(t *testing.T, secret string, clock broker.Clock) *broker.BrokerImpl {
+	t.Helper()
+	bkr, err := broker.New(broker.Config{
+		ServiceName: "db",
+		ServicePlan: "shared",
+		Dashboard: broker.DashboardConfig{
+			URL:      "https://broker.example.com",
+			Secret:   []byte(secret),
+			TokenTTL: time.Hour,
+		},
+	}, broker.WithLogger(lager.NewLogger("test")), broker.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bkr
+}
+
+func TestDashboardToken(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	bkr := newDashboardBroker(t, "secret", clock)
+	otherBroker := newDashboardBroker(t, "another-secret", clock)
+
+	spec, err := bkr.Provision(context.Background(), "instance", brokerapi.ProvisionDetails{
+		ServiceID: bkr.Catalog().Services[0].ID,
+		PlanID:    bkr.Catalog().Services[0].Plans[0].ID,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboardURL, err := url.Parse(spec.DashboardURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instanceID := path.Base(dashboardURL.Path); instanceID != "instance" {
+		t.Fatalf("expected a dashboard URL for the instance, got %s", spec.DashboardURL)
+	}
+	token := dashboardURL.Query().Get("token")
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		t.Fatalf("malformed token %q", token)
+	}
+	expires, signature := parts[0], parts[1]
+	tampered := []byte(signature)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	tests := []struct {
+		name       string
+		bkr        *broker.BrokerImpl
+		instanceID string
+		token      string
+		after      time.Duration
+		valid      bool
+	}{
+		{"valid", bkr, "instance", token, 0, true},
+		{"valid until it expires", bkr, "instance", token, time.Hour, true},
+		{"expired", bkr, "instance", token, time.Hour + time.Second, false},
+		{"other instance", bkr, "other-instance", token, 0, false},
+		{"tampered signature", bkr, "instance", expires + "." + string(tampered), 0, false},
+		{"extended expiry", bkr, "instance", "9999999999." + signature, 0, false},
+		{"other broker secret", otherBroker, "instance", token, 0, false},
+		{"token of another broker", bkr, "instance", otherBroker.DashboardToken("instance", clock.now.Add(time.Hour)), 0, false},
+		{"malformed", bkr, "instance", "not-a-token", 0, false},
+		{"empty", bkr, "instance", "", 0, false},
+	}
+	start := clock.now
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock.now = start.Add(test.after)
+			err := test.bkr.VerifyDashboardToken(test.instanceID, test.token)
+			if test.valid && err != nil {
+				t.Errorf("expected a valid token, got %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the token to be refused")
+			}
+		})
+	}
+}
+
+func TestDashboardDisabled(t *testing.T) {
+	bkr, err := broker.New(broker.Config{ServiceName: "db", ServicePlan: "shared"}, broker.WithLogger(lager.NewLogger("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a secret anyone could sign a token, so none is accepted.
+	token := bkr.DashboardToken("instance", time.Now().Add(time.Hour))
+	if err := bkr.VerifyDashboardToken("instance", token); err == nil {
+		t.Error("expected tokens to be refused without a dashboard secret")
+	}
+}
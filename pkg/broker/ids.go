@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	// LegacyIDs are "<BASE_GUID>-service-<name>" and "<BASE_GUID>-plan-<name>",
+	// as registered by earlier versions of the broker. This is the default.
+	LegacyIDs = "legacy"
+	// UUIDIDs are RFC 4122 version 5 UUIDs derived from BASE_GUID and the
+	// service and plan names.
+	UUIDIDs = "uuid"
+)
+
+// idNamespace is BASE_GUID if it is a UUID, or else a UUID derived from it.
+func idNamespace(baseGUID string) uuid.UUID {
+	if namespace := uuid.Parse(baseGUID); namespace != nil {
+		return namespace
+	}
+	return uuid.NewSHA1(uuid.NIL, []byte(baseGUID))
+}
+
+func serviceID(config Config, serviceName string) string {
+	if config.IDMode == UUIDIDs {
+		return uuid.NewSHA1(idNamespace(config.BaseGUID), []byte("service:"+serviceName)).String()
+	}
+	return config.BaseGUID + "-service-" + serviceName
+}
+
+func planID(config Config, serviceName, planName string) string {
+	if config.IDMode == UUIDIDs {
+		return uuid.NewSHA1(idNamespace(config.BaseGUID), []byte("plan:"+serviceName+"/"+planName)).String()
+	}
+	return config.BaseGUID + "-plan-" + planName
+}
+
+func checkIDMode(mode string) error {
+	if mode != LegacyIDs && mode != UUIDIDs {
+		return fmt.Errorf("unknown ID_MODE %q (expected %q or %q)", mode, LegacyIDs, UUIDIDs)
+	}
+	return nil
+}
+
+// IDMapping is the ID of a service, or of a plan when Plan is set, in the
+// current catalog and in each ID mode.
+type IDMapping struct {
+	Service string `json:"service"`
+	Plan    string `json:"plan,omitempty"`
+	ID      string `json:"id"`
+	Legacy  string `json:"legacy"`
+	UUID    string `json:"uuid"`
+}
+
+// IDMappings lists the IDs of every service and plan in the catalog.
+func (bkr *BrokerImpl) IDMappings() []IDMapping {
+	legacy, uuids := bkr.Config, bkr.Config
+	legacy.IDMode, uuids.IDMode = LegacyIDs, UUIDIDs
+	mappings := []IDMapping{}
+	for _, service := range bkr.Catalog().Services {
+		mappings = append(mappings, IDMapping{
+			Service: service.Name,
+			ID:      service.ID,
+			Legacy:  serviceID(legacy, service.Name),
+			UUID:    serviceID(uuids, service.Name),
+		})
+		for _, plan := range service.Plans {
+			mappings = append(mappings, IDMapping{
+				Service: service.Name,
+				Plan:    plan.Name,
+				ID:      plan.ID,
+				Legacy:  planID(legacy, service.Name, plan.Name),
+				UUID:    planID(uuids, service.Name, plan.Name),
+			})
+		}
+	}
+	return mappings
+}
@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// LocalBinding is what an app bound to a plan would be given, for running
+// apps outside the platform against the broker's credentials. Its IDs are
+// derived from BASE_GUID and Name, so they are the same on every run.
+type LocalBinding struct {
+	Name         string
+	Label        string
+	Plan         string
+	Tags         []string
+	InstanceID   string
+	BindingID    string
+	Credentials  interface{}
+	VolumeMounts []brokerapi.VolumeMount
+
+	// Type and Provider are the servicebinding.io type and provider: those
+	// of the plan's service_binding, or else the service name and its
+	// provider_display_name.
+	Type     string
+	Provider string
+}
+
+// LocalBinding binds an instance called name of a plan without recording
+// either. Services and plans are looked up by name or ID; the first service
+// and its first plan are used when they are empty, and name defaults to the
+// service name.
+func (bkr *BrokerImpl) LocalBinding(serviceName, planName, name string) (LocalBinding, error) {
+	service, plan, err := bkr.findServicePlan(serviceName, planName)
+	if err != nil {
+		return LocalBinding{}, err
+	}
+	if !plan.bindable(service) {
+		return LocalBinding{}, fmt.Errorf("plan %s is not bindable", plan.Name)
+	}
+	if plan.RouteService != nil {
+		return LocalBinding{}, fmt.Errorf("plan %s is a route service, its bindings have no credentials", plan.Name)
+	}
+	if name == "" {
+		name = service.Name
+	}
+	namespace := idNamespace(bkr.Config.BaseGUID)
+	binding := LocalBinding{
+		Name:         name,
+		Label:        service.Name,
+		Plan:         plan.Name,
+		Tags:         service.Tags,
+		InstanceID:   uuid.NewSHA1(namespace, []byte("local-instance:"+name)).String(),
+		BindingID:    uuid.NewSHA1(namespace, []byte("local-binding:"+name)).String(),
+		VolumeMounts: plan.VolumeMounts,
+		Type:         service.Name,
+		Provider:     service.ProviderDisplayName,
+	}
+
+	if plan.ServiceBinding != nil {
+		binding.Type, binding.Provider = plan.ServiceBinding.Type, plan.ServiceBinding.Provider
+	}
+
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	binding.Credentials, err = bkr.currentCredentials(binding.InstanceID, binding.BindingID, plan.ID)
+	return binding, err
+}
+
+func (bkr *BrokerImpl) findServicePlan(serviceName, planName string) (CatalogService, CatalogPlan, error) {
+	for _, service := range bkr.Catalog().Services {
+		if serviceName != "" && serviceName != service.Name && serviceName != service.ID {
+			continue
+		}
+		for _, plan := range service.Plans {
+			if planName == "" || planName == plan.Name || planName == plan.ID {
+				return service, plan, nil
+			}
+		}
+		return CatalogService{}, CatalogPlan{}, fmt.Errorf("service %s has no plan %s", service.Name, planName)
+	}
+	return CatalogService{}, CatalogPlan{}, fmt.Errorf("unknown service %s", serviceName)
+}
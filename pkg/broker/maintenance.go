@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// pinPlanVersion records the plan's current maintenance_info version on the
+// instance along with the plan's current credentials, which new bindings of
+// the instance receive until it is upgraded again. Instances of plans
+// without maintenance_info always use the plan's current credentials.
+func (instance *Instance) pinPlanVersion(plan CatalogPlan) {
+	if plan.MaintenanceInfo == nil {
+		instance.MaintenanceVersion = ""
+		instance.Credentials = nil
+		return
+	}
+	instance.MaintenanceVersion = plan.MaintenanceInfo.Version
+	instance.Credentials = plan.Credentials
+}
+
+// InstanceMaintenanceInfo returns the maintenance_info an instance was last
+// provisioned, updated or upgraded at, or nil if it has none.
+func (bkr *BrokerImpl) InstanceMaintenanceInfo(instanceID string) *brokerapi.MaintenanceInfo {
+	instance, ok := bkr.FindInstance(instanceID)
+	if !ok || instance.MaintenanceVersion == "" {
+		return nil
+	}
+	maintenanceInfo := &brokerapi.MaintenanceInfo{Version: instance.MaintenanceVersion}
+	if _, plan, ok := bkr.Catalog().FindPlan(instance.PlanID); ok && plan.MaintenanceInfo != nil && plan.MaintenanceInfo.Version == instance.MaintenanceVersion {
+		maintenanceInfo.Description = plan.MaintenanceInfo.Description
+	}
+	return maintenanceInfo
+}
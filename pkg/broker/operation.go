@@ -0,0 +1,166 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// operationRetention is how long a finished operation can still be polled.
+const operationRetention = 24 * time.Hour
+
+// Operation is an asynchronous provision, update, deprovision, bind or
+// unbind. Its ID is handed to the platform as operation data and sent back
+// when polling last_operation. Operations are in progress for
+// Config.OperationDuration and then succeed.
+type Operation struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	InstanceID  string    `json:"instance_id"`
+	BindingID   string    `json:"binding_id,omitempty"`
+	PlanID      string    `json:"plan_id"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletesAt time.Time `json:"completes_at"`
+
+	LastPolledAt time.Time `json:"-"`
+}
+
+func (op Operation) state(now time.Time) brokerapi.LastOperation {
+	if now.Before(op.CompletesAt) {
+		return brokerapi.LastOperation{State: brokerapi.InProgress, Description: op.Type + " in progress"}
+	}
+	return brokerapi.LastOperation{State: brokerapi.Succeeded, Description: op.Type + " succeeded"}
+}
+
+// startOperation records a new operation and returns its ID. Callers hold
+// bkr.mu.
+func (bkr *BrokerImpl) startOperation(opType, instanceID, bindingID, planID string) string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := bkr.clock.Now()
+	op := Operation{
+		ID:          opType + "-" + hex.EncodeToString(id),
+		Type:        opType,
+		InstanceID:  instanceID,
+		BindingID:   bindingID,
+		PlanID:      planID,
+		StartedAt:   now,
+		CompletesAt: now.Add(bkr.Config.OperationDuration),
+	}
+	for id, old := range bkr.Operations {
+		if now.Sub(old.CompletesAt) > operationRetention {
+			delete(bkr.Operations, id)
+		}
+	}
+	bkr.Operations[op.ID] = op
+	return op.ID
+}
+
+// findOperation returns the operation the platform is polling for: the one
+// named by operationData, or else the latest for the instance or binding.
+// Callers hold bkr.mu.
+func (bkr *BrokerImpl) findOperation(instanceID, bindingID, operationData string) (Operation, bool, error) {
+	if operationData != "" {
+		op, ok := bkr.Operations[operationData]
+		if !ok || op.InstanceID != instanceID || op.BindingID != bindingID {
+			return op, false, brokerapi.NewFailureResponse(fmt.Errorf("Unknown operation %s", operationData), http.StatusBadRequest, "unknown-operation")
+		}
+		return op, true, nil
+	}
+	var latest Operation
+	found := false
+	for _, op := range bkr.Operations {
+		if op.InstanceID == instanceID && op.BindingID == bindingID && (!found || op.StartedAt.After(latest.StartedAt)) {
+			latest, found = op, true
+		}
+	}
+	return latest, found, nil
+}
+
+// creating reports whether an instance or binding is still being created by
+// an async provision or bind; until then it cannot be fetched. Callers hold
+// bkr.mu.
+func (bkr *BrokerImpl) creating(instanceID, bindingID string) bool {
+	op, found, _ := bkr.findOperation(instanceID, bindingID, "")
+	return found && (op.Type == "provision" || op.Type == "bind") && op.state(bkr.clock.Now()).State == brokerapi.InProgress
+}
+
+func (bkr *BrokerImpl) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	lastOperation, _, err := bkr.PollOperation(instanceID, "", details.OperationData)
+	return lastOperation, err
+}
+
+func (bkr *BrokerImpl) LastBindingOperation(ctx context.Context, instanceID string, bindingID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
+	lastOperation, _, err := bkr.PollOperation(instanceID, bindingID, details.OperationData)
+	return lastOperation, err
+}
+
+// PollOperation reports the state of an instance operation, or of a binding
+// operation when bindingID is set, and how long the platform should wait
+// before polling again while it is in progress. Platforms polling more often
+// than that are logged.
+func (bkr *BrokerImpl) PollOperation(instanceID, bindingID, operationData string) (brokerapi.LastOperation, time.Duration, error) {
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	op, found, err := bkr.findOperation(instanceID, bindingID, operationData)
+	if err != nil {
+		return brokerapi.LastOperation{}, 0, err
+	}
+	gone := brokerapi.ErrInstanceDoesNotExist
+	_, exists := bkr.Instances[instanceID]
+	if bindingID != "" {
+		gone = brokerapi.ErrBindingDoesNotExist
+		_, exists = bkr.Bindings[bindingID]
+	}
+	if !found {
+		if !exists {
+			return brokerapi.LastOperation{}, 0, gone
+		}
+		return brokerapi.LastOperation{State: brokerapi.Succeeded}, 0, nil
+	}
+
+	now := bkr.clock.Now()
+	interval := bkr.pollInterval(op.PlanID)
+	if !op.LastPolledAt.IsZero() && now.Sub(op.LastPolledAt) < interval {
+		bkr.Logger.Info("polling-too-fast", lager.Data{
+			"operation":   op.ID,
+			"instance-id": instanceID,
+			"binding-id":  bindingID,
+			"interval":    now.Sub(op.LastPolledAt).String(),
+			"advised":     interval.String(),
+		})
+	}
+	op.LastPolledAt = now
+	bkr.Operations[op.ID] = op
+
+	lastOperation := op.state(now)
+	if lastOperation.State == brokerapi.Succeeded && !exists {
+		return brokerapi.LastOperation{}, 0, gone
+	}
+	if lastOperation.State != brokerapi.InProgress {
+		interval = 0
+	}
+	return lastOperation, interval, nil
+}
+
+// OperationRetryAfter is how long the platform should wait before first
+// polling the operation.
+func (bkr *BrokerImpl) OperationRetryAfter(operationData string) time.Duration {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	return bkr.pollInterval(bkr.Operations[operationData].PlanID)
+}
+
+// pollInterval is the plan's retry_after, or else RETRY_AFTER.
+func (bkr *BrokerImpl) pollInterval(planID string) time.Duration {
+	if _, plan, ok := bkr.Catalog().FindPlan(planID); ok && plan.RetryAfter > 0 {
+		return time.Duration(plan.RetryAfter) * time.Second
+	}
+	return bkr.Config.RetryAfter
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Option customizes a broker built by New.
+type Option func(*BrokerImpl)
+
+// Clock tells the broker the time, for creation times, operation progress
+// and dashboard token expiry.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Now is the time on the broker's clock.
+func (bkr *BrokerImpl) Now() time.Time {
+	return bkr.clock.Now()
+}
+
+// CredentialProvider decides the credentials handed to a binding, in place
+// of the plan's credentials. It is called with the broker locked, so it must
+// not call back into the broker.
+type CredentialProvider interface {
+	Credentials(instance Instance, bindingID string, plan CatalogPlan) (interface{}, error)
+}
+
+// WithLogger sets the logger. By default nothing is logged.
+func WithLogger(logger lager.Logger) Option {
+	return func(bkr *BrokerImpl) {
+		bkr.Logger = logger
+	}
+}
+
+// WithStore loads instances and bindings from store when the broker is
+// created, and saves them to it after every change. By default they are
+// only kept in memory.
+func WithStore(store Store) Option {
+	return func(bkr *BrokerImpl) {
+		bkr.store = store
+	}
+}
+
+// WithClock replaces the system clock.
+func WithClock(clock Clock) Option {
+	return func(bkr *BrokerImpl) {
+		bkr.clock = clock
+	}
+}
+
+// WithCredentialProvider replaces the plan credentials given to bindings.
+func WithCredentialProvider(provider CredentialProvider) Option {
+	return func(bkr *BrokerImpl) {
+		bkr.credentials = provider
+	}
+}
@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// APIVersion is the OSB API version a platform sent in X-Broker-API-Version.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// LatestAPIVersion is the newest OSB API version the broker implements. It
+// is assumed for requests that did not come through the HTTP API.
+var LatestAPIVersion = APIVersion{Major: 2, Minor: 16}
+
+func ParseAPIVersion(header string) (APIVersion, error) {
+	var version APIVersion
+	if n, err := fmt.Sscanf(header, "%d.%d", &version.Major, &version.Minor); err != nil || n < 2 {
+		return version, fmt.Errorf("invalid API version %q", header)
+	}
+	return version, nil
+}
+
+func (v APIVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+type apiVersionKey struct{}
+
+func WithAPIVersion(ctx context.Context, version APIVersion) context.Context {
+	return context.WithValue(ctx, apiVersionKey{}, version)
+}
+
+func APIVersionFromContext(ctx context.Context) APIVersion {
+	if version, ok := ctx.Value(apiVersionKey{}).(APIVersion); ok {
+		return version
+	}
+	return LatestAPIVersion
+}
+
+// CatalogServiceResponse and CatalogPlanResponse extend the brokerapi
+// catalog with plan fields it does not know about yet.
+type CatalogServiceResponse struct {
+	brokerapi.Service
+	Plans []CatalogPlanResponse `json:"plans"`
+}
+
+type CatalogPlanResponse struct {
+	brokerapi.ServicePlan
+	PlanUpdatable          *bool `json:"plan_updateable,omitempty"`
+	MaximumPollingDuration int   `json:"maximum_polling_duration,omitempty"`
+}
+
+// CatalogResponse is the body of GET /v2/catalog for the API version in ctx.
+// Fields introduced in OSB 2.15 are left out for older platforms.
+func (bkr *BrokerImpl) CatalogResponse(ctx context.Context) (interface{}, error) {
+	services, err := bkr.Services(ctx)
+	if err != nil {
+		return nil, err
+	}
+	newer := APIVersionFromContext(ctx).AtLeast(2, 15)
+	response := []CatalogServiceResponse{}
+	for _, service := range services {
+		plans := []CatalogPlanResponse{}
+		for _, servicePlan := range service.Plans {
+			plan := CatalogPlanResponse{ServicePlan: servicePlan}
+			if _, catalogPlan, ok := bkr.Catalog().FindPlan(servicePlan.ID); ok && newer {
+				plan.PlanUpdatable = catalogPlan.PlanUpdatable
+				plan.MaximumPollingDuration = catalogPlan.MaximumPollingDuration
+			}
+			plans = append(plans, plan)
+		}
+		response = append(response, CatalogServiceResponse{Service: service, Plans: plans})
+	}
+	return map[string]interface{}{"services": response}, nil
+}
+
+// checkMaintenanceInfo compares the maintenance_info a platform sent with
+// the plan's. Platforms older than OSB 2.15 do not know about it, so it is
+// not checked for them.
+func checkMaintenanceInfo(ctx context.Context, plan CatalogPlan, requested *brokerapi.MaintenanceInfo) error {
+	if requested == nil || !APIVersionFromContext(ctx).AtLeast(2, 15) {
+		return nil
+	}
+	if plan.MaintenanceInfo == nil {
+		return brokerapi.ErrMaintenanceInfoNilConflict
+	}
+	if requested.Version != plan.MaintenanceInfo.Version {
+		return brokerapi.ErrMaintenanceInfoConflict
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package broker_test
+
+import (
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/api"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/auth"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/osbtest"
+)
+
+func TestOSBConformance(t *testing.T) {
+	configs := map[string]broker.Config{
+		"sync": {
+			ServiceName: "db",
+			ServicePlan: "shared",
+			Credentials: map[string]interface{}{"uri": "postgres://db"},
+		},
+		"sync stateful": {
+			ServiceName:  "db",
+			ServicePlan:  "shared",
+			Credentials:  map[string]interface{}{"uri": "postgres://db"},
+			FakeStateful: true,
+		},
+		"async": {
+			ServiceName:       "db",
+			ServicePlan:       "shared",
+			Credentials:       map[string]interface{}{"uri": "postgres://db"},
+			FakeAsync:         true,
+			FakeStateful:      true,
+			OperationDuration: time.Second,
+			RetryAfter:        time.Second,
+		},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			bkr, err := broker.New(config, broker.WithLogger(lager.NewLogger("test")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential := auth.Credential{Username: "admin", Password: "correct-horse-battery"}
+			handler := api.New(bkr, lager.NewLogger("test"), auth.Middleware(auth.NewBasicAuth(credential)))
+			osbtest.Suite{
+				Handler:  handler,
+				Username: credential.Username,
+				Password: credential.Password,
+				Timeout:  10 * time.Second,
+			}.Run(t)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Quota limits how many instances of a plan each organization, space or
+// namespace may have, and how many bindings each instance may have. Zero
+// means unlimited.
+type Quota struct {
+	MaxInstancesPerOrg       int `json:"max_instances_per_org,omitempty"`
+	MaxInstancesPerSpace     int `json:"max_instances_per_space,omitempty"`
+	MaxInstancesPerNamespace int `json:"max_instances_per_namespace,omitempty"`
+	MaxBindingsPerInstance   int `json:"max_bindings_per_instance,omitempty"`
+}
+
+func quotaExceeded(err error) error {
+	return brokerapi.NewFailureResponseBuilder(err, http.StatusUnprocessableEntity, "quota-exceeded").WithErrorKey("QuotaExceeded").Build()
+}
+
+// checkInstanceQuota is called with bkr.mu held.
+func (bkr *BrokerImpl) checkInstanceQuota(plan CatalogPlan, platformContext PlatformContext) error {
+	if plan.Quota == nil {
+		return nil
+	}
+	limits := []struct {
+		kind  string
+		max   int
+		field func(PlatformContext) string
+	}{
+		{"organization", plan.Quota.MaxInstancesPerOrg, func(c PlatformContext) string { return c.OrganizationGUID }},
+		{"space", plan.Quota.MaxInstancesPerSpace, func(c PlatformContext) string { return c.SpaceGUID }},
+		{"namespace", plan.Quota.MaxInstancesPerNamespace, func(c PlatformContext) string { return c.Namespace }},
+	}
+	for _, limit := range limits {
+		value := limit.field(platformContext)
+		if limit.max == 0 || value == "" {
+			continue
+		}
+		count := 0
+		for _, instance := range bkr.Instances {
+			if instance.PlanID == plan.ID && limit.field(instance.Context) == value {
+				count++
+			}
+		}
+		if count >= limit.max {
+			return quotaExceeded(fmt.Errorf("%s %s already has %d of %d allowed instances of plan %s; delete unused instances before creating more",
+				limit.kind, value, count, limit.max, plan.Name))
+		}
+	}
+	return nil
+}
+
+// checkBindingQuota is called with bkr.mu held.
+func (bkr *BrokerImpl) checkBindingQuota(plan CatalogPlan, instanceID string) error {
+	if plan.Quota == nil || plan.Quota.MaxBindingsPerInstance == 0 {
+		return nil
+	}
+	if count := bkr.instanceBindings(instanceID); count >= plan.Quota.MaxBindingsPerInstance {
+		return quotaExceeded(fmt.Errorf("service instance %s already has %d of %d allowed bindings; unbind unused apps before binding more",
+			instanceID, count, plan.Quota.MaxBindingsPerInstance))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Catalog returns the catalog currently offered by the broker. It changes
+// when the catalog file is reloaded.
+func (bkr *BrokerImpl) Catalog() Catalog {
+	bkr.catalogMu.RLock()
+	defer bkr.catalogMu.RUnlock()
+	return bkr.Config.Catalog
+}
+
+// CatalogDiff lists the services and plans, as "service" or "service/plan",
+// that a catalog reload added, removed or changed.
+type CatalogDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+func (diff CatalogDiff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+func diffCatalogs(old, new Catalog) CatalogDiff {
+	var diff CatalogDiff
+	oldServices := map[string]CatalogService{}
+	for _, service := range old.Services {
+		oldServices[service.ID] = service
+	}
+	newServices := map[string]bool{}
+	for _, service := range new.Services {
+		newServices[service.ID] = true
+		oldService, ok := oldServices[service.ID]
+		if !ok {
+			diff.Added = append(diff.Added, service.Name)
+			for _, plan := range service.Plans {
+				diff.Added = append(diff.Added, service.Name+"/"+plan.Name)
+			}
+			continue
+		}
+		withoutPlans, oldWithoutPlans := service, oldService
+		withoutPlans.Plans, oldWithoutPlans.Plans = nil, nil
+		if !reflect.DeepEqual(withoutPlans, oldWithoutPlans) {
+			diff.Changed = append(diff.Changed, service.Name)
+		}
+		oldPlans := map[string]CatalogPlan{}
+		for _, plan := range oldService.Plans {
+			oldPlans[plan.ID] = plan
+		}
+		newPlans := map[string]bool{}
+		for _, plan := range service.Plans {
+			newPlans[plan.ID] = true
+			oldPlan, ok := oldPlans[plan.ID]
+			if !ok {
+				diff.Added = append(diff.Added, service.Name+"/"+plan.Name)
+			} else if !reflect.DeepEqual(plan, oldPlan) {
+				diff.Changed = append(diff.Changed, service.Name+"/"+plan.Name)
+			}
+		}
+		for _, plan := range oldService.Plans {
+			if !newPlans[plan.ID] {
+				diff.Removed = append(diff.Removed, service.Name+"/"+plan.Name)
+			}
+		}
+	}
+	for _, service := range old.Services {
+		if !newServices[service.ID] {
+			diff.Removed = append(diff.Removed, service.Name)
+			for _, plan := range service.Plans {
+				diff.Removed = append(diff.Removed, service.Name+"/"+plan.Name)
+			}
+		}
+	}
+	return diff
+}
+
+// ReloadCatalog reads the catalog file again and swaps it in. The new
+// catalog must be valid, and must keep every plan that still has
+// instances; otherwise the current catalog stays in place.
+func (bkr *BrokerImpl) ReloadCatalog() (CatalogDiff, error) {
+	if bkr.Config.CatalogFile == "" {
+		return CatalogDiff{}, errors.New("no CATALOG_FILE to reload")
+	}
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	catalog, err := LoadCatalogFile(bkr.Config.CatalogFile)
+	if err != nil {
+		return CatalogDiff{}, err
+	}
+	if catalog, err = catalog.withDefaults(bkr.Config); err != nil {
+		return CatalogDiff{}, err
+	}
+
+	old := bkr.Catalog()
+	for _, instance := range bkr.Instances {
+		if _, _, known := old.FindPlan(instance.PlanID); !known {
+			continue
+		}
+		if _, plan, ok := catalog.FindPlan(instance.PlanID); !ok {
+			_, plan, _ = old.FindPlan(instance.PlanID)
+			return CatalogDiff{}, fmt.Errorf("plan %s still has instances (e.g. %s)", plan.Name, instance.ID)
+		}
+	}
+
+	diff := diffCatalogs(old, catalog)
+	bkr.catalogMu.Lock()
+	bkr.Config.Catalog = catalog
+	bkr.catalogMu.Unlock()
+	return diff, nil
+}
+
+// WatchCatalogFile reloads the catalog whenever the catalog file changes.
+func (bkr *BrokerImpl) WatchCatalogFile(interval time.Duration) {
+	path := bkr.Config.CatalogFile
+	logger := bkr.Logger.Session("catalog-watch", lager.Data{"path": path})
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Error("stat", err)
+			continue
+		}
+		if info.ModTime().Equal(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		bkr.LogReloadCatalog(logger)
+	}
+}
+
+// LogReloadCatalog reloads the catalog and logs the outcome.
+func (bkr *BrokerImpl) LogReloadCatalog(logger lager.Logger) {
+	diff, err := bkr.ReloadCatalog()
+	if err != nil {
+		logger.Error("catalog-reload-refused", err)
+		return
+	}
+	if diff.Empty() {
+		logger.Info("catalog-unchanged")
+		return
+	}
+	logger.Info("catalog-reloaded", lager.Data{"added": diff.Added, "removed": diff.Removed, "changed": diff.Changed})
+}
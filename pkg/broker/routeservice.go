@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/auth"
+)
+
+// RouteServiceConfig makes a plan a route service: bindings return a
+// route_service_url instead of credentials, and the service requires
+// route_forwarding.
+//
+// URL may contain {instance_id}, {binding_id} and {route}. When it is empty,
+// bindings point at the broker's built-in proxy (ROUTE_SERVICE_PROXY_URL),
+// which adds Headers to every request and, if BasicAuth is set, asks the
+// caller for one of its credentials before forwarding.
+type RouteServiceConfig struct {
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	BasicAuth []auth.Credential `json:"basic_auth,omitempty"`
+}
+
+func (bkr *BrokerImpl) routeServiceURL(plan CatalogPlan, instanceID, bindingID, route string) string {
+	if plan.RouteService == nil {
+		return ""
+	}
+	if plan.RouteService.URL == "" {
+		return fmt.Sprintf("%s/route/%s", strings.TrimRight(bkr.Config.RouteServiceProxyURL, "/"), url.PathEscape(instanceID))
+	}
+	return strings.NewReplacer(
+		"{instance_id}", url.PathEscape(instanceID),
+		"{binding_id}", url.PathEscape(bindingID),
+		"{route}", url.QueryEscape(route),
+	).Replace(plan.RouteService.URL)
+}
+
+// RouteBound reports whether target is on a route bound to the instance,
+// that is whether the built-in proxy may forward a request to it. Routes are
+// host names, optionally with a path, such as "app.example.com/api". The
+// router only forwards HTTP(S) on their default ports, so other ports of a
+// bound host are refused unless the route names the port.
+func (bkr *BrokerImpl) RouteBound(instanceID string, target *url.URL) bool {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	for _, binding := range bkr.Bindings {
+		if binding.InstanceID != instanceID || binding.Route == "" {
+			continue
+		}
+		route, err := url.Parse("//" + binding.Route)
+		if err != nil || !strings.EqualFold(route.Hostname(), target.Hostname()) || !routePortAllowed(route.Port(), target) {
+			continue
+		}
+		routePath := strings.TrimRight(route.Path, "/")
+		if routePath == "" || target.Path == routePath || strings.HasPrefix(target.Path, routePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func routePortAllowed(routePort string, target *url.URL) bool {
+	port := target.Port()
+	if port == "" {
+		switch target.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if routePort != "" {
+		return port == routePort
+	}
+	return port == "80" || port == "443"
+}
+
+// RouteServicePlan returns the route service configuration of an instance
+// whose plan uses the built-in proxy.
+func (bkr *BrokerImpl) RouteServicePlan(instanceID string) (*RouteServiceConfig, bool) {
+	instance, ok := bkr.FindInstance(instanceID)
+	if !ok {
+		return nil, false
+	}
+	_, plan, ok := bkr.Catalog().FindPlan(instance.PlanID)
+	if !ok || plan.RouteService == nil || plan.RouteService.URL != "" {
+		return nil, false
+	}
+	return plan.RouteService, true
+}
@@ -0,0 +1,89 @@
+package broker_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+func TestRouteBound(t *testing.T) {
+	ctx := context.Background()
+	bkr, err := broker.New(broker.Config{
+		BaseGUID:             "29140B3F-0E69-4C7E-8A35",
+		RouteServiceProxyURL: "https://broker.example.com",
+		Catalog: broker.Catalog{Services: []broker.CatalogService{{
+			Name:  "proxy",
+			Plans: []broker.CatalogPlan{{Name: "headers", RouteService: &broker.RouteServiceConfig{}}},
+		}}},
+	}, broker.WithLogger(lager.NewLogger("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceID := "29140B3F-0E69-4C7E-8A35-service-proxy"
+	planID := "29140B3F-0E69-4C7E-8A35-plan-headers"
+	for _, instanceID := range []string{"instance", "other-instance"} {
+		if _, err := bkr.Provision(ctx, instanceID, brokerapi.ProvisionDetails{ServiceID: serviceID, PlanID: planID}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bind := func(instanceID, bindingID, route string) {
+		t.Helper()
+		if _, err := bkr.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+			ServiceID:    serviceID,
+			PlanID:       planID,
+			BindResource: &brokerapi.BindResource{Route: route},
+		}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bind("instance", "app", "app.example.com")
+	bind("instance", "api", "api.example.com/app")
+	bind("instance", "admin", "admin.example.com:8443")
+	bind("instance", "unbound", "old.example.com")
+	bind("other-instance", "other", "other.example.com")
+	if _, err := bkr.Unbind(ctx, "instance", "unbound", brokerapi.UnbindDetails{ServiceID: serviceID, PlanID: planID}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		bound  bool
+	}{
+		{"exact host", "https://app.example.com/", true},
+		{"exact host with a path", "https://app.example.com/some/page?q=1", true},
+		{"host in another case", "https://APP.example.com/", true},
+		{"default port", "http://app.example.com:80/", true},
+		{"route path", "https://api.example.com/app", true},
+		{"below the route path", "https://api.example.com/app/users", true},
+		{"sibling of the route path", "https://api.example.com/application", false},
+		{"outside the route path", "https://api.example.com/", false},
+		// The router only forwards on 80 and 443, so another port of a bound
+		// host is not a request for the bound route.
+		{"other port of a bound host", "https://app.example.com:8080/", false},
+		{"port named by the route", "https://admin.example.com:8443/", true},
+		{"default port of a route with a port", "https://admin.example.com/", false},
+		{"other host", "https://evil.example.com/", false},
+		{"subdomain of a bound host", "https://x.app.example.com/", false},
+		{"bound host as a prefix", "https://app.example.com.evil.com/", false},
+		{"metadata address", "http://169.254.169.254/latest/meta-data/", false},
+		{"route of an unbound binding", "https://old.example.com/", false},
+		{"route of another instance", "https://other.example.com/", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := url.Parse(test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bkr.RouteBound("instance", target); got != test.bound {
+				t.Errorf("RouteBound(%s) = %v, expected %v", test.target, got, test.bound)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// ServiceBindingConfig makes a plan's bindings return servicebinding.io
+// shaped credentials: flat string values, plus type and provider. Type
+// defaults to the service name and Provider to its provider_display_name.
+// Plans always have a type, so it replaces any type key in the credentials;
+// a provider key is only kept when the plan has no provider.
+type ServiceBindingConfig struct {
+	Type     string `json:"type,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// credentials shapes the credentials of a binding. They may come from the
+// instance or a CredentialProvider rather than the catalog, so they are only
+// checked here, at bind time.
+func (config ServiceBindingConfig) credentials(credentials interface{}) (interface{}, error) {
+	entries, err := ServiceBindingEntries(credentials, config.Type, config.Provider)
+	if err != nil {
+		return nil, brokerapi.NewFailureResponse(err, http.StatusInternalServerError, "servicebinding-credentials")
+	}
+	// Credentials read back from the state file are map[string]interface{},
+	// so they compare equal to these.
+	flattened := map[string]interface{}{}
+	for key, value := range entries {
+		flattened[key] = value
+	}
+	return flattened, nil
+}
+
+// servicebinding.io entries are files in the binding's directory.
+var serviceBindingEntryName = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// ServiceBindingEntries flattens credentials into the entries of a
+// servicebinding.io binding. Each top-level key becomes an entry; strings are
+// kept as they are and other values are JSON-encoded. bindingType and
+// provider replace the credentials' own type and provider keys unless they
+// are empty.
+func ServiceBindingEntries(credentials interface{}, bindingType, provider string) (map[string]string, error) {
+	entries := map[string]string{}
+	if credentials != nil {
+		fields, ok := credentials.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("credentials must be a JSON object to be used as a servicebinding.io binding")
+		}
+		for key, value := range fields {
+			if !serviceBindingEntryName.MatchString(key) || key == "." || key == ".." {
+				return nil, fmt.Errorf("credentials key %q is not a valid servicebinding.io entry name", key)
+			}
+			if s, ok := value.(string); ok {
+				entries[key] = s
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = string(encoded)
+		}
+	}
+	if bindingType != "" {
+		entries["type"] = bindingType
+	}
+	if provider != "" {
+		entries["provider"] = provider
+	}
+	if entries["type"] == "" {
+		return nil, fmt.Errorf("servicebinding.io bindings need a type")
+	}
+	return entries, nil
+}
@@ -0,0 +1,179 @@
+package broker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Instance is the broker's record of a provisioned service instance.
+type Instance struct {
+	ID         string          `json:"id"`
+	ServiceID  string          `json:"service_id"`
+	PlanID     string          `json:"plan_id"`
+	Parameters interface{}     `json:"parameters,omitempty"`
+	Context    PlatformContext `json:"context"`
+	CreatedAt  time.Time       `json:"created_at"`
+
+	MaintenanceVersion string      `json:"maintenance_version,omitempty"`
+	Credentials        interface{} `json:"credentials,omitempty"`
+}
+
+func (instance Instance) spec() brokerapi.GetInstanceDetailsSpec {
+	return brokerapi.GetInstanceDetailsSpec{
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+		Parameters: instance.Parameters,
+	}
+}
+
+// Binding is the broker's record of a service binding.
+type Binding struct {
+	ID          string          `json:"id"`
+	InstanceID  string          `json:"instance_id"`
+	PlanID      string          `json:"plan_id"`
+	Credentials interface{}     `json:"credentials"`
+	Parameters  interface{}     `json:"parameters,omitempty"`
+	Context     PlatformContext `json:"context"`
+	Shared      bool            `json:"shared,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+
+	RouteServiceURL string                  `json:"route_service_url,omitempty"`
+	Route           string                  `json:"route,omitempty"`
+	VolumeMounts    []brokerapi.VolumeMount `json:"volume_mounts,omitempty"`
+}
+
+func (binding Binding) spec() brokerapi.GetBindingSpec {
+	return brokerapi.GetBindingSpec{
+		Credentials:     binding.Credentials,
+		RouteServiceURL: binding.RouteServiceURL,
+		VolumeMounts:    binding.VolumeMounts,
+		Parameters:      binding.Parameters,
+	}
+}
+
+// instanceBindings counts the bindings of an instance. Callers hold bkr.mu.
+func (bkr *BrokerImpl) instanceBindings(instanceID string) int {
+	count := 0
+	for _, binding := range bkr.Bindings {
+		if binding.InstanceID == instanceID {
+			count++
+		}
+	}
+	return count
+}
+
+// State is a snapshot of every instance and binding known to the broker.
+type State struct {
+	Instances []Instance `json:"instances"`
+	Bindings  []Binding  `json:"bindings"`
+}
+
+func (bkr *BrokerImpl) ListInstances() []Instance {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	return bkr.sortedInstances()
+}
+
+func (bkr *BrokerImpl) sortedInstances() []Instance {
+	instances := []Instance{}
+	for _, instance := range bkr.Instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].CreatedAt.Before(instances[j].CreatedAt) })
+	return instances
+}
+
+func (bkr *BrokerImpl) FindInstance(instanceID string) (Instance, bool) {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	instance, ok := bkr.Instances[instanceID]
+	return instance, ok
+}
+
+func (bkr *BrokerImpl) FindBinding(bindingID string) (Binding, bool) {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	binding, ok := bkr.Bindings[bindingID]
+	return binding, ok
+}
+
+func (bkr *BrokerImpl) ListBindings() []Binding {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	return bkr.sortedBindings()
+}
+
+func (bkr *BrokerImpl) sortedBindings() []Binding {
+	bindings := []Binding{}
+	for _, binding := range bkr.Bindings {
+		bindings = append(bindings, binding)
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].CreatedAt.Before(bindings[j].CreatedAt) })
+	return bindings
+}
+
+// DeleteInstance forgets an instance and all of its bindings, whatever the
+// platform thinks. It reports whether the instance existed.
+func (bkr *BrokerImpl) DeleteInstance(instanceID string) bool {
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	_, ok := bkr.Instances[instanceID]
+	bkr.deleteInstance(instanceID)
+	bkr.save()
+	return ok
+}
+
+// deleteInstance forgets an instance and its bindings. Callers hold bkr.mu.
+func (bkr *BrokerImpl) deleteInstance(instanceID string) {
+	delete(bkr.Instances, instanceID)
+	for id, binding := range bkr.Bindings {
+		if binding.InstanceID == instanceID {
+			delete(bkr.Bindings, id)
+		}
+	}
+}
+
+// DeleteBinding forgets a binding. It reports whether the binding existed.
+func (bkr *BrokerImpl) DeleteBinding(bindingID string) bool {
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	_, ok := bkr.Bindings[bindingID]
+	delete(bkr.Bindings, bindingID)
+	bkr.save()
+	return ok
+}
+
+func (bkr *BrokerImpl) ExportState() State {
+	bkr.mu.RLock()
+	defer bkr.mu.RUnlock()
+	return bkr.snapshot()
+}
+
+// snapshot is the current state. Callers hold bkr.mu.
+func (bkr *BrokerImpl) snapshot() State {
+	return State{
+		Instances: bkr.sortedInstances(),
+		Bindings:  bkr.sortedBindings(),
+	}
+}
+
+// ImportState replaces all instances and bindings with those in state.
+func (bkr *BrokerImpl) ImportState(state State) {
+	bkr.mu.Lock()
+	defer bkr.mu.Unlock()
+	bkr.setState(state)
+	bkr.save()
+}
+
+func (bkr *BrokerImpl) setState(state State) {
+	bkr.Instances = map[string]Instance{}
+	for _, instance := range state.Instances {
+		bkr.Instances[instance.ID] = instance
+	}
+	bkr.Bindings = map[string]Binding{}
+	for _, binding := range state.Bindings {
+		bkr.Bindings[binding.ID] = binding
+	}
+}
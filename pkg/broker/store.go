@@ -0,0 +1,19 @@
+package broker
+
+// Store persists the broker's instances and bindings. Save is called with
+// the broker locked after every change.
+type Store interface {
+	Load() (State, error)
+	Save(State) error
+}
+
+// save writes the current state to the store, if there is one. Callers hold
+// bkr.mu.
+func (bkr *BrokerImpl) save() {
+	if bkr.store == nil {
+		return
+	}
+	if err := bkr.store.Save(bkr.snapshot()); err != nil {
+		bkr.Logger.Error("save-state", err)
+	}
+}
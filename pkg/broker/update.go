@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// planChangeAllowed reports whether an instance may move from one plan to
+// another. Both plans must belong to the same service, the current plan
+// must be updatable (its own plan_updateable, or else the service's), and
+// the target must be listed in the current plan's updatable_to, if it has
+// one.
+func (catalog Catalog) planChangeAllowed(fromPlanID, toPlanID string) bool {
+	fromService, fromPlan, ok := catalog.FindPlan(fromPlanID)
+	if !ok {
+		return false
+	}
+	updatable := fromService.PlanUpdatable
+	if fromPlan.PlanUpdatable != nil {
+		updatable = *fromPlan.PlanUpdatable
+	}
+	toService, toPlan, ok := catalog.FindPlan(toPlanID)
+	if !ok || toService.ID != fromService.ID || !updatable {
+		return false
+	}
+	return len(fromPlan.UpdatableTo) == 0 || contains(fromPlan.UpdatableTo, toPlan.Name)
+}
+
+// mergeParameters applies update parameters on top of the stored ones. Keys
+// in the update replace existing keys; a null value removes the key.
+func mergeParameters(existing interface{}, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return existing, nil
+	}
+	var update interface{}
+	if err := json.Unmarshal(raw, &update); err != nil {
+		return nil, brokerapi.ErrRawParamsInvalid
+	}
+	updateMap, ok := update.(map[string]interface{})
+	if !ok {
+		return update, nil
+	}
+	merged := map[string]interface{}{}
+	if existingMap, ok := existing.(map[string]interface{}); ok {
+		for k, v := range existingMap {
+			merged[k] = v
+		}
+	}
+	for k, v := range updateMap {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// updateInstance is called with bkr.mu held. An instance the broker does
+// not know, e.g. one provisioned before a restart, is recorded as if it were
+// being provisioned now, so the access control and quota of its plan apply.
+func (bkr *BrokerImpl) updateInstance(ctx context.Context, instanceID string, details brokerapi.UpdateDetails) error {
+	instance, known := bkr.Instances[instanceID]
+	if !known {
+		instance = Instance{
+			ID:        instanceID,
+			ServiceID: details.ServiceID,
+			PlanID:    details.PreviousValues.PlanID,
+			Context:   updateContext(details),
+			CreatedAt: bkr.clock.Now(),
+		}
+		if instance.PlanID == "" {
+			instance.PlanID = details.PlanID
+		}
+	}
+
+	if details.PlanID != "" && details.PlanID != instance.PlanID {
+		if !bkr.Catalog().planChangeAllowed(instance.PlanID, details.PlanID) {
+			return brokerapi.ErrPlanChangeNotSupported
+		}
+		_, plan, _ := bkr.Catalog().FindPlan(details.PlanID)
+		if err := bkr.checkAccess(plan, instance.Context); err != nil {
+			return err
+		}
+		if err := bkr.checkInstanceQuota(plan, instance.Context); err != nil {
+			return err
+		}
+		instance.PlanID = details.PlanID
+		instance.pinPlanVersion(plan)
+	}
+
+	parameters, err := mergeParameters(instance.Parameters, details.GetRawParameters())
+	if err != nil {
+		return err
+	}
+	instance.Parameters = parameters
+
+	_, plan, ok := bkr.Catalog().FindPlan(instance.PlanID)
+	if !ok {
+		return brokerapi.NewFailureResponse(fmt.Errorf("Unknown plan ID %s", instance.PlanID), http.StatusBadRequest, "update")
+	}
+	if !known {
+		if err := bkr.checkAccess(plan, instance.Context); err != nil {
+			return err
+		}
+		if err := bkr.checkInstanceQuota(plan, instance.Context); err != nil {
+			return err
+		}
+	}
+	if err := checkMaintenanceInfo(ctx, plan, details.MaintenanceInfo); err != nil {
+		return err
+	}
+	if details.MaintenanceInfo != nil && APIVersionFromContext(ctx).AtLeast(2, 15) && details.MaintenanceInfo.Version != instance.MaintenanceVersion {
+		bkr.Logger.Info("upgrade", lager.Data{"instance-id": instanceID, "from": instance.MaintenanceVersion, "to": details.MaintenanceInfo.Version})
+		instance.pinPlanVersion(plan)
+	}
+	bkr.Instances[instanceID] = instance
+	bkr.save()
+	return nil
+}
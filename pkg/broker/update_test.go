@@ -0,0 +1,106 @@
+package broker_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+)
+
+func newRestrictedBroker(t *testing.T) *broker.BrokerImpl {
+	t.Helper()
+	bkr, err := broker.New(broker.Config{
+		BaseGUID: "29140B3F-0E69-4C7E-8A35",
+		Catalog: broker.Catalog{Services: []broker.CatalogService{{
+			Name:          "db",
+			PlanUpdatable: true,
+			Plans: []broker.CatalogPlan{
+				{Name: "dev"},
+				{
+					Name:   "prod",
+					Access: &broker.AccessControl{AllowOrgs: []string{"prod-org"}},
+					Quota:  &broker.Quota{MaxInstancesPerOrg: 1},
+				},
+			},
+		}}},
+	}, broker.WithLogger(lager.NewLogger("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bkr
+}
+
+func platformContext(t *testing.T, org string) json.RawMessage {
+	t.Helper()
+	raw, err := json.Marshal(map[string]string{"platform": "cloudfoundry", "organization_guid": org, "space_guid": "space"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// An update of an instance the broker does not know records it, and must
+// not get around the plan's access control or quota.
+func TestUpdateUnknownInstance(t *testing.T) {
+	ctx := context.Background()
+	serviceID := "29140B3F-0E69-4C7E-8A35-service-db"
+	devPlanID := "29140B3F-0E69-4C7E-8A35-plan-dev"
+	prodPlanID := "29140B3F-0E69-4C7E-8A35-plan-prod"
+
+	tests := []struct {
+		name    string
+		org     string
+		details brokerapi.UpdateDetails
+	}{
+		{"denied, target plan only", "dev-org", brokerapi.UpdateDetails{ServiceID: serviceID, PlanID: prodPlanID}},
+		{"denied, previous plan only", "dev-org", brokerapi.UpdateDetails{ServiceID: serviceID, PreviousValues: brokerapi.PreviousValues{PlanID: prodPlanID}}},
+		{"denied, plan change", "dev-org", brokerapi.UpdateDetails{ServiceID: serviceID, PlanID: prodPlanID, PreviousValues: brokerapi.PreviousValues{PlanID: devPlanID}}},
+		{"over quota", "prod-org", brokerapi.UpdateDetails{ServiceID: serviceID, PlanID: prodPlanID}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bkr := newRestrictedBroker(t)
+			if _, err := bkr.Provision(ctx, "existing", brokerapi.ProvisionDetails{
+				ServiceID:  serviceID,
+				PlanID:     prodPlanID,
+				RawContext: platformContext(t, "prod-org"),
+			}, false); err != nil {
+				t.Fatal(err)
+			}
+
+			details := test.details
+			details.RawContext = platformContext(t, test.org)
+			_, err := bkr.Update(ctx, "adopted", details, false)
+			if err == nil {
+				t.Fatal("expected the update to be refused")
+			}
+			if _, err := bkr.GetInstance(ctx, "adopted"); err == nil {
+				t.Error("expected the refused instance not to be recorded")
+			}
+		})
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		bkr := newRestrictedBroker(t)
+		_, err := bkr.Update(ctx, "adopted", brokerapi.UpdateDetails{
+			ServiceID:      serviceID,
+			PlanID:         prodPlanID,
+			RawContext:     platformContext(t, "prod-org"),
+			PreviousValues: brokerapi.PreviousValues{PlanID: prodPlanID},
+		}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance, err := bkr.GetInstance(ctx, "adopted")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.PlanID != prodPlanID {
+			t.Errorf("expected plan %s, got %s", prodPlanID, instance.PlanID)
+		}
+	})
+}
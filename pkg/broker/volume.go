@@ -0,0 +1,29 @@
+package broker
+
+import (
+	"fmt"
+)
+
+// validateVolumeMounts checks the plan's volume_mounts and fills in the
+// OSB defaults: mode "rw" and device_type "shared".
+func (plan *CatalogPlan) validateVolumeMounts() error {
+	for i := range plan.VolumeMounts {
+		mount := &plan.VolumeMounts[i]
+		if mount.Driver == "" || mount.ContainerDir == "" || mount.Device.VolumeId == "" {
+			return fmt.Errorf("plan %s has a volume mount without driver, container_dir or device.volume_id", plan.Name)
+		}
+		if mount.Mode == "" {
+			mount.Mode = "rw"
+		}
+		if mount.Mode != "r" && mount.Mode != "rw" {
+			return fmt.Errorf("plan %s has a volume mount with unknown mode %q (expected \"r\" or \"rw\")", plan.Name, mount.Mode)
+		}
+		if mount.DeviceType == "" {
+			mount.DeviceType = "shared"
+		}
+		if mount.DeviceType != "shared" {
+			return fmt.Errorf("plan %s has a volume mount with unsupported device_type %q", plan.Name, mount.DeviceType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/auth"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+)
+
+var page = template.Must(template.New("dashboard").Funcs(template.FuncMap{"json": toJSON}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Service}} {{.Plan}} - {{.Instance.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+pre { margin: 0; }
+</style>
+</head>
+<body>
+<h1>{{.Service}}</h1>
+<table>
+<tr><th>Instance</th><td>{{.Instance.ID}}</td></tr>
+<tr><th>Plan</th><td>{{.Plan}}</td></tr>
+<tr><th>Created</th><td>{{.Instance.CreatedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Parameters</th><td><pre>{{.Parameters}}</pre></td></tr>
+</table>
+
+<h2>Bindings</h2>
+{{if .Bindings}}
+<table>
+<tr><th>Binding</th><th>Created</th><th>Parameters</th></tr>
+{{range .Bindings}}<tr><td>{{.ID}}</td><td>{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}</td><td><pre>{{json .Parameters}}</pre></td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No bindings.</p>
+{{end}}
+
+<h2>Credentials</h2>
+{{if .ShowCredentials}}
+<pre>{{.Credentials}}</pre>
+{{else}}
+<p><a href="{{.CredentialsLink}}">Show credentials</a> (requires broker credentials)</p>
+{{end}}
+</body>
+</html>
+`))
+
+func toJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, _ := json.MarshalIndent(v, "", "  ")
+	return string(data)
+}
+
+type view struct {
+	Service         string
+	Plan            string
+	Instance        broker.Instance
+	Parameters      string
+	Bindings        []broker.Binding
+	ShowCredentials bool
+	Credentials     string
+	CredentialsLink string
+}
+
+// Handler serves /dashboard/{instance_id}. Access requires the signed token
+// from the instance's dashboard_url; credentials are only shown to viewers
+// who also pass the broker's own authentication.
+type Handler struct {
+	Broker     *broker.BrokerImpl
+	Authorizer auth.Authorizer
+	Logger     lager.Logger
+}
+
+func NewHandler(bkr *broker.BrokerImpl, authorizer auth.Authorizer, logger lager.Logger) http.Handler {
+	handler := &Handler{Broker: bkr, Authorizer: authorizer, Logger: logger.Session("dashboard")}
+	router := mux.NewRouter()
+	router.HandleFunc("/dashboard/{instance_id}", handler.show).Methods("GET")
+	return router
+}
+
+func (h *Handler) show(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instance_id"]
+	token := r.URL.Query().Get("token")
+	if err := h.Broker.VerifyDashboardToken(instanceID, token); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	instance, ok := h.Broker.FindInstance(instanceID)
+	if !ok {
+		http.Error(w, "Unknown instance ID "+instanceID, http.StatusNotFound)
+		return
+	}
+
+	service, plan, _ := h.Broker.Catalog().FindPlan(instance.PlanID)
+	v := view{
+		Service:         service.Name,
+		Plan:            plan.Name,
+		Instance:        instance,
+		Parameters:      toJSON(instance.Parameters),
+		Bindings:        []broker.Binding{},
+		CredentialsLink: "?" + url.Values{"token": {token}, "credentials": {"true"}}.Encode(),
+	}
+	for _, binding := range h.Broker.ListBindings() {
+		if binding.InstanceID == instanceID {
+			v.Bindings = append(v.Bindings, binding)
+		}
+	}
+	if r.URL.Query().Get("credentials") == "true" {
+		if h.Authorizer != nil && !h.Authorizer.Authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="worlds-simplest-service-broker"`)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		credentials, err := h.Broker.InstanceCredentials(instanceID)
+		if err != nil {
+			h.Logger.Error("credentials", err, lager.Data{"instance-id": instanceID})
+			http.Error(w, "Could not get credentials", http.StatusInternalServerError)
+			return
+		}
+		v.ShowCredentials = true
+		v.Credentials = toJSON(credentials)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, v); err != nil {
+		h.Logger.Error("render", err)
+	}
+}
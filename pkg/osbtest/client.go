@@ -0,0 +1,107 @@
+package osbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (resp response) decode(v interface{}) error {
+	if err := json.Unmarshal(resp.body, v); err != nil {
+		return fmt.Errorf("decoding %s: %s", resp.body, err)
+	}
+	return nil
+}
+
+// retryAfter returns the Retry-After header in seconds, if there is one.
+func (resp response) retryAfter() (time.Duration, bool, error) {
+	value := resp.header.Get("Retry-After")
+	if value == "" {
+		return 0, false, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false, fmt.Errorf("Retry-After %q is not a number of seconds", value)
+	}
+	return time.Duration(seconds) * time.Second, true, nil
+}
+
+type request struct {
+	method     string
+	path       string
+	query      url.Values
+	body       interface{}
+	apiVersion string
+
+	noAPIVersion bool
+	anonymous    bool
+}
+
+func (s *Suite) do(req request) (response, error) {
+	var body io.Reader
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			return response{}, err
+		}
+		body = bytes.NewReader(data)
+	}
+	target := req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var httpReq *http.Request
+	if s.Handler != nil {
+		httpReq = httptest.NewRequest(req.method, target, body)
+	} else {
+		var err error
+		httpReq, err = http.NewRequest(req.method, strings.TrimRight(s.URL, "/")+target, body)
+		if err != nil {
+			return response{}, err
+		}
+	}
+	if req.apiVersion != "" {
+		httpReq.Header.Set("X-Broker-API-Version", req.apiVersion)
+	}
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if s.Username != "" && !req.anonymous {
+		httpReq.SetBasicAuth(s.Username, s.Password)
+	}
+
+	if s.Handler != nil {
+		recorder := httptest.NewRecorder()
+		s.Handler.ServeHTTP(recorder, httpReq)
+		return response{status: recorder.Code, header: recorder.Header(), body: recorder.Body.Bytes()}, nil
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return response{}, err
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return response{}, err
+	}
+	return response{status: httpResp.StatusCode, header: httpResp.Header, body: data}, nil
+}
@@ -0,0 +1,448 @@
+// Package osbtest checks that a service broker follows the Open Service
+// Broker API. It drives a broker's HTTP handler, or a broker listening at a
+// URL, through catalog, provision, fetch, update, bind, unbind and
+// deprovision, once synchronously and once with accepts_incomplete=true, and
+// reports every status code, body or header that breaks the spec.
+//
+// It is meant to be called from a go test:
+//
+//	bkr, _ := broker.New(broker.Config{ServiceName: "db", ServicePlan: "shared"})
+//	handler := api.New(bkr, logger, auth.Middleware(auth.NewBasicAuth(credential)))
+//	osbtest.Suite{Handler: handler, Username: "admin", Password: "secret"}.Run(t)
+package osbtest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const DefaultAPIVersion = "2.16"
+
+// TB is the part of testing.TB the suite reports to.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Logf(format string, args ...interface{})
+}
+
+// Suite is a broker under test. Set Handler to test an http.Handler in
+// process, or URL to test a running broker. ServiceID and PlanID pick the
+// plan to provision; by default the first bindable plan in the catalog is
+// used.
+type Suite struct {
+	Handler http.Handler
+	URL     string
+	Client  *http.Client
+
+	Username string
+	Password string
+
+	APIVersion string
+	ServiceID  string
+	PlanID     string
+
+	// PollInterval is how long to wait between last_operation polls when
+	// the broker sends no Retry-After, and Timeout how long to wait for an
+	// async operation to finish.
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+type run struct {
+	*Suite
+	t      TB
+	failed bool
+}
+
+// Run checks the broker and reports whether it passed.
+func (s Suite) Run(t TB) bool {
+	t.Helper()
+	if s.APIVersion == "" {
+		s.APIVersion = DefaultAPIVersion
+	}
+	if s.PollInterval == 0 {
+		s.PollInterval = time.Second
+	}
+	if s.Timeout == 0 {
+		s.Timeout = time.Minute
+	}
+	r := &run{Suite: &s, t: t}
+
+	r.checkAPIVersionRequired()
+	if s.Username != "" {
+		r.checkAuthRequired()
+	}
+	catalog, ok := r.checkCatalog()
+	if !ok {
+		return false
+	}
+	service, plan, ok := r.pickPlan(catalog)
+	if !ok {
+		return false
+	}
+	r.lifecycle(service, plan, false)
+	r.lifecycle(service, plan, true)
+	return !r.failed
+}
+
+func (r *run) errorf(format string, args ...interface{}) {
+	r.t.Helper()
+	r.failed = true
+	r.t.Errorf(format, args...)
+}
+
+// call sends a request and checks the status code and the headers every
+// response must have. It returns false if the request failed or the status
+// was not one of the expected ones.
+func (r *run) call(step string, req request, expected ...int) (response, bool) {
+	r.t.Helper()
+	if req.apiVersion == "" && !req.noAPIVersion {
+		req.apiVersion = r.APIVersion
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		r.errorf("%s: %s", step, err)
+		return resp, false
+	}
+	r.t.Logf("%s: %s %s: %d", step, req.method, req.path, resp.status)
+	// The spec leaves the body of 401 responses to the authentication
+	// scheme.
+	if resp.status == http.StatusUnauthorized {
+		return resp, len(expected) == 1 && expected[0] == http.StatusUnauthorized
+	}
+	if len(resp.body) > 0 && !strings.HasPrefix(resp.header.Get("Content-Type"), "application/json") {
+		r.errorf("%s: Content-Type is %q, expected application/json", step, resp.header.Get("Content-Type"))
+	}
+	if _, _, err := resp.retryAfter(); err != nil {
+		r.errorf("%s: %s", step, err)
+	}
+	if resp.status >= 400 {
+		var failure brokerapi.ErrorResponse
+		if err := resp.decode(&failure); err != nil {
+			r.errorf("%s: error response is not a JSON object: %s", step, err)
+		}
+	}
+	for _, status := range expected {
+		if resp.status == status {
+			return resp, true
+		}
+	}
+	r.errorf("%s: status %d, expected one of %v: %s", step, resp.status, expected, resp.body)
+	return resp, false
+}
+
+func (r *run) checkAPIVersionRequired() {
+	r.t.Helper()
+	r.call("catalog without X-Broker-API-Version", request{method: "GET", path: "/v2/catalog", noAPIVersion: true}, http.StatusPreconditionFailed)
+}
+
+func (r *run) checkAuthRequired() {
+	r.t.Helper()
+	r.call("catalog without credentials", request{method: "GET", path: "/v2/catalog", anonymous: true}, http.StatusUnauthorized)
+}
+
+func (r *run) checkCatalog() (brokerapi.CatalogResponse, bool) {
+	r.t.Helper()
+	var catalog brokerapi.CatalogResponse
+	resp, ok := r.call("catalog", request{method: "GET", path: "/v2/catalog"}, http.StatusOK)
+	if !ok {
+		return catalog, false
+	}
+	if err := resp.decode(&catalog); err != nil {
+		r.errorf("catalog: %s", err)
+		return catalog, false
+	}
+	if len(catalog.Services) == 0 {
+		r.errorf("catalog: no services")
+		return catalog, false
+	}
+	failed := r.failed
+	r.failed = false
+	defer func() { r.failed = r.failed || failed }()
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	for _, service := range catalog.Services {
+		if service.ID == "" || service.Name == "" || service.Description == "" {
+			r.errorf("catalog: service %q is missing an id, name or description", service.Name)
+		}
+		if ids[service.ID] || names[service.Name] {
+			r.errorf("catalog: service %q does not have a unique id and name", service.Name)
+		}
+		ids[service.ID], names[service.Name] = true, true
+		if len(service.Plans) == 0 {
+			r.errorf("catalog: service %q has no plans", service.Name)
+		}
+		planNames := map[string]bool{}
+		for _, plan := range service.Plans {
+			if plan.ID == "" || plan.Name == "" || plan.Description == "" {
+				r.errorf("catalog: plan %q of service %q is missing an id, name or description", plan.Name, service.Name)
+			}
+			if ids[plan.ID] || planNames[plan.Name] {
+				r.errorf("catalog: plan %q of service %q does not have a unique id and name", plan.Name, service.Name)
+			}
+			ids[plan.ID], planNames[plan.Name] = true, true
+		}
+	}
+	return catalog, !r.failed
+}
+
+func (r *run) pickPlan(catalog brokerapi.CatalogResponse) (brokerapi.Service, brokerapi.ServicePlan, bool) {
+	r.t.Helper()
+	var candidates []int
+	for i, service := range catalog.Services {
+		if r.ServiceID == "" || service.ID == r.ServiceID {
+			candidates = append(candidates, i)
+		}
+	}
+	for _, wantBindable := range []bool{true, false} {
+		for _, i := range candidates {
+			service := catalog.Services[i]
+			for _, plan := range service.Plans {
+				if r.PlanID != "" && plan.ID != r.PlanID {
+					continue
+				}
+				if planBindable(service, plan) == wantBindable {
+					return service, plan, true
+				}
+			}
+		}
+	}
+	r.errorf("catalog: no plan matches service ID %q and plan ID %q", r.ServiceID, r.PlanID)
+	return brokerapi.Service{}, brokerapi.ServicePlan{}, false
+}
+
+func planBindable(service brokerapi.Service, plan brokerapi.ServicePlan) bool {
+	if plan.Bindable != nil {
+		return *plan.Bindable
+	}
+	return service.Bindable
+}
+
+// lifecycle provisions, fetches, updates, binds, unbinds and deprovisions an
+// instance of plan. Each step checks that repeating it is handled as the spec
+// requires.
+func (r *run) lifecycle(service brokerapi.Service, plan brokerapi.ServicePlan, async bool) {
+	r.t.Helper()
+	variant := "sync"
+	query := url.Values{}
+	if async {
+		variant = "async"
+		query.Set("accepts_incomplete", "true")
+	}
+	ids := url.Values{"service_id": {service.ID}, "plan_id": {plan.ID}}
+	for name, values := range query {
+		ids[name] = values
+	}
+	accepted := []int{http.StatusOK}
+	if async {
+		accepted = append(accepted, http.StatusAccepted)
+	}
+
+	instanceID := uuid.New()
+	instancePath := "/v2/service_instances/" + instanceID
+	orgGUID, spaceGUID := uuid.New(), uuid.New()
+	provision := map[string]interface{}{
+		"service_id":        service.ID,
+		"plan_id":           plan.ID,
+		"organization_guid": orgGUID,
+		"space_guid":        spaceGUID,
+		"context": map[string]interface{}{
+			"platform":          "cloudfoundry",
+			"organization_guid": orgGUID,
+			"space_guid":        spaceGUID,
+		},
+	}
+	step := variant + " provision"
+	resp, ok := r.call(step, request{method: "PUT", path: instancePath, query: query, body: provision}, created(async)...)
+	if !ok || r.asyncRequired(step, resp, plan) || !r.finish(step, resp, instancePath, ids, false) {
+		return
+	}
+	r.call(step+" again", request{method: "PUT", path: instancePath, query: query, body: provision}, http.StatusOK)
+	conflicting := copyBody(provision)
+	conflicting["parameters"] = map[string]interface{}{"osbtest": "conflict"}
+	r.call(step+" with other parameters", request{method: "PUT", path: instancePath, query: query, body: conflicting}, http.StatusConflict)
+
+	if service.InstancesRetrievable {
+		step = variant + " fetch instance"
+		if resp, ok := r.call(step, request{method: "GET", path: instancePath}, http.StatusOK); ok {
+			var instance brokerapi.GetInstanceResponse
+			if err := resp.decode(&instance); err != nil {
+				r.errorf("%s: %s", step, err)
+			} else if instance.ServiceID != service.ID || instance.PlanID != plan.ID {
+				r.errorf("%s: got service %s and plan %s, expected %s and %s", step, instance.ServiceID, instance.PlanID, service.ID, plan.ID)
+			}
+		}
+	}
+
+	step = variant + " update"
+	update := map[string]interface{}{
+		"service_id":      service.ID,
+		"plan_id":         plan.ID,
+		"previous_values": map[string]interface{}{"service_id": service.ID, "plan_id": plan.ID},
+	}
+	if resp, ok := r.call(step, request{method: "PATCH", path: instancePath, query: query, body: update}, accepted...); ok {
+		r.finish(step, resp, instancePath, ids, false)
+	}
+
+	if planBindable(service, plan) {
+		r.bindingLifecycle(variant, service, plan, instancePath, query, ids, accepted)
+	}
+
+	step = variant + " deprovision"
+	if resp, ok := r.call(step, request{method: "DELETE", path: instancePath, query: ids}, accepted...); ok {
+		r.finish(step, resp, instancePath, ids, true)
+	}
+	r.call(step+" again", request{method: "DELETE", path: instancePath, query: ids}, http.StatusGone)
+}
+
+func (r *run) bindingLifecycle(variant string, service brokerapi.Service, plan brokerapi.ServicePlan, instancePath string, query, ids url.Values, accepted []int) {
+	r.t.Helper()
+	bindingPath := instancePath + "/service_bindings/" + uuid.New()
+	appGUID := uuid.New()
+	bind := map[string]interface{}{
+		"service_id":    service.ID,
+		"plan_id":       plan.ID,
+		"app_guid":      appGUID,
+		"bind_resource": map[string]interface{}{"app_guid": appGUID},
+	}
+	step := variant + " bind"
+	resp, ok := r.call(step, request{method: "PUT", path: bindingPath, query: query, body: bind}, created(len(accepted) > 1)...)
+	if !ok || r.asyncRequired(step, resp, plan) {
+		return
+	}
+	if resp.status == http.StatusCreated {
+		r.checkBinding(step, resp)
+	}
+	if !r.finish(step, resp, bindingPath, ids, false) {
+		return
+	}
+	if resp, ok := r.call(step+" again", request{method: "PUT", path: bindingPath, query: query, body: bind}, http.StatusOK); ok {
+		r.checkBinding(step+" again", resp)
+	}
+
+	if service.BindingsRetrievable {
+		step = variant + " fetch binding"
+		if resp, ok := r.call(step, request{method: "GET", path: bindingPath}, http.StatusOK); ok {
+			r.checkBinding(step, resp)
+		}
+	}
+
+	step = variant + " unbind"
+	if resp, ok := r.call(step, request{method: "DELETE", path: bindingPath, query: ids}, accepted...); ok {
+		r.finish(step, resp, bindingPath, ids, true)
+	}
+	r.call(step+" again", request{method: "DELETE", path: bindingPath, query: ids}, http.StatusGone)
+}
+
+// created lists the statuses a provision or bind may answer with. Brokers
+// may refuse a synchronous request with 422 AsyncRequired.
+func created(async bool) []int {
+	if async {
+		return []int{http.StatusCreated, http.StatusAccepted}
+	}
+	return []int{http.StatusCreated, http.StatusUnprocessableEntity}
+}
+
+// asyncRequired reports whether the broker refused a synchronous request,
+// which it must do with the AsyncRequired error.
+func (r *run) asyncRequired(step string, resp response, plan brokerapi.ServicePlan) bool {
+	r.t.Helper()
+	if resp.status != http.StatusUnprocessableEntity {
+		return false
+	}
+	var failure brokerapi.ErrorResponse
+	if err := resp.decode(&failure); err == nil && failure.Error != "AsyncRequired" {
+		r.errorf("%s: 422 with error %q, expected AsyncRequired", step, failure.Error)
+	} else if err == nil {
+		r.t.Logf("%s: plan %s requires accepts_incomplete, skipping", step, plan.Name)
+	}
+	return true
+}
+
+func (r *run) checkBinding(step string, resp response) {
+	r.t.Helper()
+	var binding map[string]interface{}
+	if err := resp.decode(&binding); err != nil {
+		r.errorf("%s: %s", step, err)
+		return
+	}
+	if _, ok := binding["credentials"]; !ok && binding["route_service_url"] == nil && binding["volume_mounts"] == nil && binding["syslog_drain_url"] == nil {
+		r.errorf("%s: binding has no credentials, route_service_url, volume_mounts or syslog_drain_url: %s", step, resp.body)
+	}
+}
+
+// finish waits for an accepted operation to complete by polling
+// last_operation. Deprovisions and unbinds are also complete when polling
+// returns 410 Gone.
+func (r *run) finish(step string, resp response, path string, ids url.Values, deleting bool) bool {
+	r.t.Helper()
+	if resp.status != http.StatusAccepted {
+		return true
+	}
+	var accepted brokerapi.AsyncBindResponse
+	if err := resp.decode(&accepted); err != nil {
+		r.errorf("%s: %s", step, err)
+		return false
+	}
+	query := url.Values{"service_id": ids["service_id"], "plan_id": ids["plan_id"]}
+	if accepted.OperationData != "" {
+		query.Set("operation", accepted.OperationData)
+	}
+	wait := r.PollInterval
+	if retryAfter, ok, _ := resp.retryAfter(); ok {
+		wait = retryAfter
+	}
+	deadline := time.Now().Add(r.Timeout)
+	for {
+		if time.Now().Add(wait).After(deadline) {
+			r.errorf("%s: still in progress after %s", step, r.Timeout)
+			return false
+		}
+		time.Sleep(wait)
+
+		expected := []int{http.StatusOK}
+		if deleting {
+			expected = append(expected, http.StatusGone)
+		}
+		poll, ok := r.call(step+" last_operation", request{method: "GET", path: path + "/last_operation", query: query}, expected...)
+		if !ok {
+			return false
+		}
+		if poll.status == http.StatusGone {
+			return true
+		}
+		var lastOperation brokerapi.LastOperationResponse
+		if err := poll.decode(&lastOperation); err != nil {
+			r.errorf("%s last_operation: %s", step, err)
+			return false
+		}
+		switch lastOperation.State {
+		case brokerapi.Succeeded:
+			return true
+		case brokerapi.Failed:
+			r.errorf("%s: failed: %s", step, lastOperation.Description)
+			return false
+		case brokerapi.InProgress:
+		default:
+			r.errorf("%s last_operation: unknown state %q", step, lastOperation.State)
+			return false
+		}
+		wait = r.PollInterval
+		if retryAfter, ok, _ := poll.retryAfter(); ok {
+			wait = retryAfter
+		}
+	}
+}
+
+func copyBody(body map[string]interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for name, value := range body {
+		copied[name] = value
+	}
+	return copied
+}
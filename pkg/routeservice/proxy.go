@@ -0,0 +1,86 @@
+package routeservice
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/auth"
+	"github.com/cloudfoundry-community/worlds-simplest-service-broker/pkg/broker"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+)
+
+const (
+	forwardedURLHeader   = "X-CF-Forwarded-Url"
+	proxySignatureHeader = "X-CF-Proxy-Signature"
+	proxyMetadataHeader  = "X-CF-Proxy-Metadata"
+)
+
+// Proxy serves /route/{instance_id}, a route service for instances of plans
+// with a route_service and no url of their own. The Cloud Foundry router
+// sends requests here with the original URL in X-CF-Forwarded-Url; they are
+// sent back to that URL with the plan's headers added, but only if it is on
+// a route bound to the instance. X-CF-Proxy-Signature
+// and X-CF-Proxy-Metadata are passed through untouched so that the router
+// accepts the forwarded request.
+type Proxy struct {
+	Broker *broker.BrokerImpl
+	Logger lager.Logger
+}
+
+func NewHandler(bkr *broker.BrokerImpl, logger lager.Logger) http.Handler {
+	proxy := &Proxy{Broker: bkr, Logger: logger.Session("route-service")}
+	router := mux.NewRouter()
+	router.PathPrefix("/route/{instance_id}").HandlerFunc(proxy.serve)
+	return router
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	instanceID := mux.Vars(r)["instance_id"]
+	config, ok := p.Broker.RouteServicePlan(instanceID)
+	if !ok {
+		http.Error(w, "Unknown route service instance ID "+instanceID, http.StatusNotFound)
+		return
+	}
+	forwardedURL := r.Header.Get(forwardedURLHeader)
+	if forwardedURL == "" || r.Header.Get(proxySignatureHeader) == "" {
+		http.Error(w, "Missing "+forwardedURLHeader+" or "+proxySignatureHeader+" header", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(forwardedURL)
+	if err != nil || target.Host == "" {
+		http.Error(w, "Invalid "+forwardedURLHeader+" header", http.StatusBadRequest)
+		return
+	}
+	if !p.Broker.RouteBound(instanceID, target) {
+		p.Logger.Info("unbound-route", lager.Data{"instance-id": instanceID, "url": forwardedURL})
+		http.Error(w, "Route is not bound to route service instance "+instanceID, http.StatusForbidden)
+		return
+	}
+	if len(config.BasicAuth) > 0 {
+		if !auth.NewBasicAuth(config.BasicAuth...).Authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+target.Host+`"`)
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del("Authorization")
+	}
+
+	logger := p.Logger.Session("forward", lager.Data{"instance-id": instanceID, "url": forwardedURL})
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL = target
+			req.Host = target.Host
+			for name, value := range config.Headers {
+				req.Header.Set(name, value)
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Error("failed", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	reverseProxy.ServeHTTP(w, r)
+}